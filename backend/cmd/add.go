@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	dl "github.com/ONESHO1/FINDR/backend/internal/songdownload"
+)
+
+/*
+add [-duplicates skip|alias|prompt|keep] <spotify track/playlist link>
+add [-title T] [-artist A] [-duplicates skip|alias|prompt|keep] <audio file>
+*/
+func addCommand(args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	title := fs.String("title", "", "song title for a local file (default: from a 'Title - Artist' file name)")
+	artist := fs.String("artist", "", "song artist for a local file")
+	opts := dl.DefaultAddOptions()
+	fs.Func("duplicates", "what to do with a song whose audio is already indexed: skip, alias, prompt or keep (default \""+opts.Duplicates+"\")", func(action string) error {
+		if err := dl.ValidateDuplicates(action); err != nil {
+			return err
+		}
+		opts.Duplicates = action
+		return nil
+	})
+
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'add'")
+	}
+	if fs.NArg() < 1 {
+		log.Logger.Fatal("Missing Spotify link or audio file for 'add' command")
+	}
+
+	// local files (WAV/FLAC don't even need ffmpeg)
+	if _, err := os.Stat(fs.Arg(0)); err == nil {
+		if err := dl.AddFile(fs.Arg(0), *title, *artist, opts); err != nil {
+			log.Logger.WithError(err).Fatal("Could not add song")
+		}
+		return
+	}
+
+	// get audio file from spotify link
+	dl.GetSongFromSpotify(fs.Arg(0), opts)
+}
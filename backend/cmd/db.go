@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	"github.com/ONESHO1/FINDR/backend/internal/indexfile"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+/*
+db migrate up
+db migrate status
+db gc [-dry-run] [-empty-songs]
+db export <file>
+db import [-merge] <file>, '-' for stdin
+
+no export to stdout, logs go there too
+*/
+func dbCommand(args []string) {
+	if len(args) < 1 {
+		log.Logger.Fatal("Expected 'db migrate', 'db gc', 'db export' or 'db import'")
+	}
+
+	switch args[0] {
+	case "migrate":
+		dbMigrate(args[1:])
+	case "gc":
+		dbGc(args[1:])
+	case "export":
+		dbExport(args[1:])
+	case "import":
+		dbImport(args[1:])
+	default:
+		log.Logger.Fatalf("Unknown db command: %s. Expected 'migrate', 'gc', 'export' or 'import'", args[0])
+	}
+}
+
+func dbMigrate(args []string) {
+	if len(args) < 1 {
+		log.Logger.Fatal("Expected 'db migrate up' or 'db migrate status'")
+	}
+
+	client := openMaintenanceClient()
+	defer client.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := client.MigrateUp(ctx)
+		if err != nil {
+			log.Logger.WithError(err).Fatal("Migration failed")
+		}
+		if len(applied) == 0 {
+			latest, err := db.LatestVersion()
+			if err != nil {
+				log.Logger.WithError(err).Fatal("Could not read the migrations")
+			}
+			fmt.Printf("Schema is up to date (version %d)\n", latest)
+			return
+		}
+		fmt.Printf("Applied %d migration(s), schema is at version %d\n", len(applied), applied[len(applied)-1].Version)
+	case "status":
+		statuses, current, err := client.MigrationStatus(ctx)
+		if err != nil {
+			log.Logger.WithError(err).Fatal("Could not read the schema version")
+		}
+		printMigrationStatus(statuses, current)
+	default:
+		log.Logger.Fatalf("Unknown migrate command: %s. Expected 'up' or 'status'", args[0])
+	}
+}
+
+// removes fingerprints of songs that no longer exist, and on request songs that have no fingerprints
+func dbGc(args []string) {
+	var opts db.GcOptions
+
+	fs := flag.NewFlagSet("db gc", flag.ExitOnError)
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "only count the orphan fingerprints")
+	fs.BoolVar(&opts.EmptySongs, "empty-songs", false, "also delete songs without fingerprints (silent or very short songs have none too)")
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'db gc'")
+	}
+
+	client := openMaintenanceClient()
+	defer client.Close()
+
+	result, err := client.CollectGarbage(context.Background(), opts)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Garbage collection failed")
+	}
+
+	if opts.DryRun {
+		fmt.Printf("%d orphan fingerprint(s), run 'db gc' to delete them\n", result.OrphanFingerprints)
+	} else {
+		fmt.Printf("Deleted %d orphan fingerprint(s)\n", result.OrphanFingerprints)
+	}
+	if result.DeletedEmptySongs {
+		fmt.Printf("Deleted %d song(s) without fingerprints\n", result.EmptySongs)
+	} else if result.EmptySongs > 0 {
+		fmt.Printf("%d song(s) without fingerprints, 'db gc -empty-songs' deletes them\n", result.EmptySongs)
+	}
+	if !opts.DryRun && !result.Validated {
+		fmt.Println("Run 'db migrate up' so deleting a song also deletes its fingerprints")
+	}
+}
+
+// songs, metadata and fingerprints to a portable file, see indexfile for the format
+func dbExport(args []string) {
+	if len(args) < 1 {
+		log.Logger.Fatal("Missing file for 'db export'")
+	}
+	path := args[0]
+
+	client := openSongsClient()
+	defer client.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not create export file")
+	}
+
+	result, err := indexfile.Export(context.Background(), client, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path) // don't leave half a file that looks like an export
+		log.Logger.WithError(err).Fatal("Export failed")
+	}
+
+	fmt.Printf("Exported %d song(s) and %d fingerprint(s) to %s\n", result.Songs, result.Fingerprints, path)
+}
+
+func dbImport(args []string) {
+	fs := flag.NewFlagSet("db import", flag.ExitOnError)
+	merge := fs.Bool("merge", false, "add to an index that already has songs, skipping the ones it has")
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'db import'")
+	}
+	if fs.NArg() < 1 {
+		log.Logger.Fatal("Missing file for 'db import'")
+	}
+	path := fs.Arg(0)
+
+	in := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Logger.WithError(err).Fatal("Could not open import file")
+		}
+		defer file.Close()
+		in = file
+	}
+
+	client := openSongsClient()
+	defer client.Close()
+
+	result, header, err := indexfile.Import(context.Background(), client, in, indexfile.ImportOptions{Merge: *merge})
+	if errors.Is(err, indexfile.ErrIndexNotEmpty) {
+		log.Logger.WithError(err).Fatal("Import failed, use -merge to add to it")
+	}
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Import failed")
+	}
+
+	fmt.Printf("Imported %d song(s) and %d fingerprint(s) from an export made %s\n", result.Songs, result.Fingerprints, header.Created.Local().Format(time.DateTime))
+	if result.SkippedSongs > 0 {
+		fmt.Printf("Skipped %d song(s) already in the index and %d fingerprint(s)\n", result.SkippedSongs, result.SkippedFingerprints)
+	}
+}
+
+// a connection that doesn't migrate or check the schema on its own
+func openMaintenanceClient() *db.PostgresClient {
+	cfg, err := db.ConfigFromEnv()
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Invalid database configuration")
+	}
+	client, err := db.OpenPostgres(cfg)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not connect to the database")
+	}
+	return client
+}
+
+func printMigrationStatus(statuses []db.MigrationStatus, current int) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.Applied {
+			applied = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	tw.Flush()
+
+	latest, err := db.LatestVersion()
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not read the migrations")
+	}
+	fmt.Printf("\nDatabase is at version %d, this build knows up to %d\n", current, latest)
+	switch {
+	case current > latest:
+		log.Logger.Warnf("%v, upgrade FINDR before using this database", db.ErrSchemaTooNew)
+	case current < latest:
+		fmt.Println("Run 'db migrate up' to apply the pending migrations")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/match"
+)
+
+// devices list
+func devicesCommand(args []string) {
+	if len(args) < 1 || args[0] != "list" {
+		log.Logger.Fatal("Expected 'devices list'")
+	}
+
+	devices, err := match.ListCaptureDevices()
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not list capture devices")
+	}
+
+	if len(devices) == 0 {
+		fmt.Fprintln(os.Stderr, "No capture devices found")
+		return
+	}
+
+	fmt.Println("Capture devices ->")
+	for _, d := range devices {
+		def := ""
+		if d.IsDefault {
+			def = " (default)"
+		}
+		fmt.Printf("\t- [%s] %s%s\n", d.ID, d.Name, def)
+	}
+}
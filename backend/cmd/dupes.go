@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/match"
+)
+
+/*
+dupes [-min-similarity 0.5]
+
+looks every song up against the rest of the index, a lookup per song so it takes a while
+*/
+func dupesCommand(args []string) {
+	fs := flag.NewFlagSet("dupes", flag.ExitOnError)
+	minSimilarity := fs.Float64("min-similarity", match.DUPLICATE_MIN_SIMILARITY, "share of the shorter song's hashes that have to line up (0 to 1)")
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'dupes'")
+	}
+
+	client := openSongsClient()
+	defer client.Close()
+
+	// stop at the next song on ctrl-c, with what was found so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	songs, err := client.ListSongs(db.SongPage{Sort: "id"})
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not list songs")
+	}
+	matcher := match.NewMatcher(client, match.DefaultOptions())
+
+	type pair struct{ a, b uint32 }
+	seen := make(map[pair]bool)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tARTIST\tSAME AS\tTITLE\tARTIST\tSIMILARITY\tOFFSET")
+	found := 0
+
+	for i, song := range songs {
+		if ctx.Err() != nil {
+			break
+		}
+		if i > 0 && i%100 == 0 {
+			log.Logger.Infof("Checked %d of %d songs", i, len(songs))
+		}
+
+		fingerprints, err := client.SongFingerprints(ctx, song.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Logger.WithError(err).Fatal("Could not get fingerprints")
+		}
+		hashes := make(map[uint32]uint32, len(fingerprints))
+		for _, fp := range fingerprints {
+			if _, ok := hashes[fp.Address]; !ok {
+				hashes[fp.Address] = fp.AnchorTimeMs // earliest, they're by anchor time
+			}
+		}
+
+		duplicates, err := matcher.FindDuplicates(ctx, hashes, song.ID, *minSimilarity)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Logger.WithError(err).Fatal("Could not look for duplicates")
+		}
+		for _, dupe := range duplicates {
+			key := pair{min(song.ID, dupe.Song.ID), max(song.ID, dupe.Song.ID)}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			found++
+			offset := time.Duration(dupe.OffsetMs) * time.Millisecond
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%.0f%%\t%s\n", song.ID, song.Title, song.Artist, dupe.Song.ID, dupe.Song.Title, dupe.Song.Artist, 100*dupe.Similarity, offset)
+		}
+	}
+
+	if ctx.Err() != nil {
+		log.Logger.Warn("Interrupted, the report only covers the songs checked so far")
+	}
+	if found == 0 {
+		fmt.Println("No duplicates found")
+		return
+	}
+	tw.Flush()
+	fmt.Printf("\n%d duplicate pair(s). 'songs rm <id>' removes one, 'add -duplicates alias' keeps its name as an alias\n", found)
+}
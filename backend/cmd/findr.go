@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/match"
+)
+
+/*
+findr [-device-id ID | -device NAME] [-rate HZ] [-channels N] [-duration 25s] [-tolerant] [-explain [-explain-out FILE]] [-timeout 30s] [-preprocess CHAIN] [-stop-list N] [-stop-mode skip|weight] [-json]
+findr --stdin [-format s16le|f32le|wav] [-rate HZ] [-channels N] [-duration 25s [-json] [-explain [-explain-out FILE]] | -segment 10s]
+*/
+func findrCommand(args []string) {
+	opts := match.DefaultRecordOptions()
+	streamOpts := match.DefaultStreamOptions()
+
+	fs := flag.NewFlagSet("findr", flag.ExitOnError)
+	fs.StringVar(&opts.DeviceID, "device-id", "", "capture device ID (see 'devices list')")
+	fs.StringVar(&opts.DeviceName, "device", "", "capture device name, exact or partial")
+	rate := fs.Uint("rate", uint(opts.SampleRate), "capture sample rate in Hz")
+	channels := fs.Uint("channels", uint(opts.Channels), "number of capture channels")
+	fs.DurationVar(&opts.Duration, "duration", opts.Duration, "how long to record for")
+	stdin := fs.Bool("stdin", false, "read audio from stdin instead of the microphone")
+	fs.StringVar(&streamOpts.Format, "format", streamOpts.Format, "stdin format: s16le, f32le or wav")
+	fs.DurationVar(&streamOpts.Segment, "segment", 0, "with --stdin, keep recognising every segment until EOF")
+	fs.BoolVar(&opts.Matching.TempoTolerant, "tolerant", false, "also match sped-up/pitched versions and tempo changes (slower)")
+	fs.Float64Var(&opts.Matching.MaxShift, "max-shift", opts.Matching.MaxShift, "with -tolerant, biggest speed/pitch change looked for (0.12 = 12%)")
+	explain := fs.Bool("explain", false, "write a JSON report of why the top candidates matched next to the recording")
+	fs.IntVar(&opts.Explain, "explain-top", 5, "with -explain, how many candidates to explain")
+	fs.StringVar(&opts.ExplainOut, "explain-out", "", "with -explain, file to write the report to, - for stderr (default: next to the recording, stderr with --stdin)")
+	fs.DurationVar(&opts.Matching.Timeout, "timeout", 0, "give up on a lookup after this long (0 for no limit)")
+	preprocessFlag(fs, &opts.Matching.Preprocess)
+	stopListFlags(fs, &opts.Matching)
+	fs.BoolVar(&opts.JSON, "json", false, "print the matches and recording diagnostics as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'findr'")
+	}
+
+	// stdout is only the JSON, so it can be piped or redirected as is
+	if opts.JSON {
+		log.UseStderr()
+	}
+
+	opts.SampleRate = uint32(*rate)
+	opts.Channels = uint32(*channels)
+	if !*explain {
+		opts.Explain = 0
+	}
+
+	if *stdin {
+		streamOpts.SampleRate = int(*rate)
+		streamOpts.Channels = int(*channels)
+		streamOpts.Duration = opts.Duration
+		streamOpts.Matching = opts.Matching
+		streamOpts.Explain = opts.Explain
+		streamOpts.ExplainOut = opts.ExplainOut
+		streamOpts.JSON = opts.JSON
+		// stop at the next segment on ctrl-c
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := match.FindFromStream(ctx, os.Stdin, streamOpts); err != nil {
+			log.Logger.WithError(err).Fatal("Could not recognise audio from stdin")
+		}
+		return
+	}
+
+	match.RecordAndFind(opts)
+}
+
+// -preprocess "dc,highpass=40,denoise,peak", defaults to FINDR_QUERY_PREPROCESS or audio.DEFAULT_QUERY_CHAIN (none)
+func preprocessFlag(fs *flag.FlagSet, chain *audio.Chain) {
+	fs.Func("preprocess", "query clean up before matching, 'none' or stages from dc, highpass[=HZ], denoise[=STRENGTH], peak[=LEVEL], rms[=DBFS] (default \""+chain.String()+"\")", func(spec string) error {
+		parsed, err := audio.ParseChain(spec)
+		if err != nil {
+			return err
+		}
+		*chain = parsed
+		return nil
+	})
+}
+
+// -stop-list 500 -stop-mode weight, default to FINDR_STOPLIST_MAX_SONGS / FINDR_STOPLIST_MODE
+func stopListFlags(fs *flag.FlagSet, opts *match.Options) {
+	fs.IntVar(&opts.MaxAddressSongs, "stop-list", opts.MaxAddressSongs, "stop-list hashes found in more than this many songs (0 to turn it off)")
+	fs.Func("stop-mode", "what to do with stop-listed hashes, skip or weight (default \""+opts.StopList+"\")", func(mode string) error {
+		if err := match.ValidateStopListMode(mode); err != nil {
+			return err
+		}
+		opts.StopList = mode
+		return nil
+	})
+}
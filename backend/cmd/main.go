@@ -6,8 +6,6 @@ import (
 	"github.com/joho/godotenv"
 
 	"github.com/ONESHO1/FINDR/backend/internal/log"
-	dl "github.com/ONESHO1/FINDR/backend/internal/songdownload"
-	"github.com/ONESHO1/FINDR/backend/internal/match"
 )
 
 func main(){
@@ -17,7 +15,7 @@ func main(){
 	log.Init()
 
 	if len(os.Args) < 2 {
-		log.Logger.Fatal("Expected 'add' or 'findr' commands")
+		log.Logger.Fatal("Expected 'add', 'findr', 'segment', 'monitor', 'devices', 'songs', 'stats', 'dupes' or 'db' commands")
 	}
 
 	// for i, arg := range os.Args{
@@ -27,19 +25,25 @@ func main(){
 	switch os.Args[1] {
 	// test - "https://open.spotify.com/track/4lH6nENd1y81jp7Yt9lTBX?si=31d16035bbd643c3"
 	case "add":
-		// TODO: Get audio file from file path
-		// saveSong(os.Args[2])
-
-		if len(os.Args) < 3 {
-			log.Logger.Fatal("Missing Spotify link for 'add' command")
-		}
-
-		// get audio file from spotify link
-		dl.GetSongFromSpotify(os.Args[2])
+		addCommand(os.Args[2:])
 	case "findr":
 		// log.Logger.Info("still havent implemented")
-		match.RecordAndFind()
+		findrCommand(os.Args[2:])
+	case "devices":
+		devicesCommand(os.Args[2:])
+	case "segment":
+		segmentCommand(os.Args[2:])
+	case "monitor":
+		monitorCommand(os.Args[2:])
+	case "songs":
+		songsCommand(os.Args[2:])
+	case "stats":
+		statsCommand(os.Args[2:])
+	case "dupes":
+		dupesCommand(os.Args[2:])
+	case "db":
+		dbCommand(os.Args[2:])
 	default:
-		log.Logger.Fatalf("Unknown command: %s. Expected 'add' or 'findr'", os.Args[1])
+		log.Logger.Fatalf("Unknown command: %s. Expected 'add', 'findr', 'segment', 'monitor', 'devices', 'songs', 'stats', 'dupes' or 'db'", os.Args[1])
 	}
 }
\ No newline at end of file
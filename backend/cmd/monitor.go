@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/monitor"
+)
+
+/*
+monitor run [-segment 10s] [-min-score 20] [-max-gap 1] [-min-play 2] [-timeout 10s] <source>...
+monitor report [-source SRC] [-since 24h] [-summary]
+monitor serve [-addr :8000] <file.wav>
+*/
+func monitorCommand(args []string) {
+	if len(args) < 1 {
+		log.Logger.Fatal("Expected 'monitor run', 'monitor report' or 'monitor serve'")
+	}
+
+	switch args[0] {
+	case "run":
+		monitorRun(args[1:])
+	case "report":
+		monitorReport(args[1:])
+	case "serve":
+		monitorServe(args[1:])
+	default:
+		log.Logger.Fatalf("Unknown monitor command: %s. Expected 'run', 'report' or 'serve'", args[0])
+	}
+}
+
+func monitorRun(args []string) {
+	opts := monitor.DefaultOptions()
+
+	fs := flag.NewFlagSet("monitor run", flag.ExitOnError)
+	fs.DurationVar(&opts.Segment, "segment", opts.Segment, "length of audio recognised at a time")
+	fs.Float64Var(&opts.MinScore, "min-score", opts.MinScore, "minimum score for a segment to count as a match")
+	fs.IntVar(&opts.MaxGap, "max-gap", opts.MaxGap, "unmatched segments allowed before a play ends")
+	fs.IntVar(&opts.MinPlay, "min-play", opts.MinPlay, "matched segments needed before a play is logged")
+	fs.DurationVar(&opts.RetryDelay, "retry", opts.RetryDelay, "wait before reopening a dropped stream")
+	fs.DurationVar(&opts.Matching.Timeout, "timeout", opts.Matching.Timeout, "give up on a segment's lookup after this long")
+	preprocessFlag(fs, &opts.Matching.Preprocess)
+	stopListFlags(fs, &opts.Matching)
+
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'monitor run'")
+	}
+	if fs.NArg() < 1 {
+		log.Logger.Fatal("Missing sources for 'monitor run' (files, pipes or http streams)")
+	}
+
+	// stop cleanly so the songs currently playing still get logged
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := monitor.Run(ctx, fs.Args(), opts); err != nil {
+		log.Logger.WithError(err).Fatal("Monitoring failed")
+	}
+}
+
+func monitorReport(args []string) {
+	var filter db.AirplayFilter
+
+	fs := flag.NewFlagSet("monitor report", flag.ExitOnError)
+	fs.StringVar(&filter.Source, "source", "", "only plays on this source")
+	since := fs.Duration("since", 24*time.Hour, "only plays that started within this long ago (0 for all)")
+	summary := fs.Bool("summary", false, "one line per song with play counts and airtime")
+
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'monitor report'")
+	}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+
+	if err := monitor.PrintReport(os.Stdout, filter, *summary); err != nil {
+		log.Logger.WithError(err).Fatal("Could not build airplay report")
+	}
+}
+
+// a stand-in radio stream for trying out 'monitor run' on http sources
+func monitorServe(args []string) {
+	fs := flag.NewFlagSet("monitor serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8000", "address to listen on")
+
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'monitor serve'")
+	}
+	if fs.NArg() < 1 {
+		log.Logger.Fatal("Missing the WAV file for 'monitor serve'")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := monitor.Serve(ctx, *addr, fs.Arg(0)); err != nil {
+		log.Logger.WithError(err).Fatal("Could not serve stream")
+	}
+}
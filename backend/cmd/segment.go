@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/match"
+)
+
+// segment [-window 15s] [-hop 5s] [-min-score 20] [-format json|cue] [-o FILE] <recording>
+func segmentCommand(args []string) {
+	opts := match.DefaultSegmentOptions()
+
+	fs := flag.NewFlagSet("segment", flag.ExitOnError)
+	fs.DurationVar(&opts.Window, "window", opts.Window, "length of each recognised window")
+	fs.DurationVar(&opts.Hop, "hop", opts.Hop, "how far the window moves each step")
+	fs.Float64Var(&opts.MinScore, "min-score", opts.MinScore, "minimum score for a window to count as identified")
+	fs.IntVar(&opts.MaxGap, "max-gap", opts.MaxGap, "unidentified windows allowed inside a song before it gets split")
+	fs.BoolVar(&opts.Matching.TempoTolerant, "tolerant", false, "also match sped-up/pitched versions and tempo changes (slower)")
+	fs.Float64Var(&opts.Matching.MaxShift, "max-shift", opts.Matching.MaxShift, "with -tolerant, biggest speed/pitch change looked for (0.12 = 12%)")
+	fs.DurationVar(&opts.Matching.Timeout, "timeout", 0, "give up on a window's lookup after this long (0 for no limit)")
+	preprocessFlag(fs, &opts.Matching.Preprocess)
+	stopListFlags(fs, &opts.Matching)
+	format := fs.String("format", "json", "output format: json or cue")
+	output := fs.String("o", "", "write the timeline to this file instead of stdout")
+
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'segment'")
+	}
+	if fs.NArg() < 1 {
+		log.Logger.Fatal("Missing recording for 'segment' command")
+	}
+	if *format != "json" && *format != "cue" {
+		log.Logger.Fatalf("Unknown format: %s. Expected 'json' or 'cue'", *format)
+	}
+
+	// the timeline on stdout is the output, keep the log out of it
+	if *output == "" {
+		log.UseStderr()
+	}
+
+	timeline, err := match.SegmentFile(context.Background(), fs.Arg(0), opts)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not segment recording")
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Logger.WithError(err).WithField("output", *output).Fatal("Could not create output file")
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "cue" {
+		err = match.WriteCueSheet(w, timeline)
+	} else {
+		err = match.WriteTimelineJSON(w, timeline)
+	}
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not write timeline")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+/*
+songs list [-page N] [-limit 50] [-sort id|title|artist|album|duration|ingested] [-desc]
+songs search [-limit 50] <text>
+songs show <id>
+songs rm [-yes] <id>
+*/
+func songsCommand(args []string) {
+	if len(args) < 1 {
+		log.Logger.Fatal("Expected 'songs list', 'songs search', 'songs show' or 'songs rm'")
+	}
+
+	switch args[0] {
+	case "list":
+		songsList(args[1:])
+	case "search":
+		songsSearch(args[1:])
+	case "show":
+		songsShow(args[1:])
+	case "rm":
+		songsRm(args[1:])
+	default:
+		log.Logger.Fatalf("Unknown songs command: %s. Expected 'list', 'search', 'show' or 'rm'", args[0])
+	}
+}
+
+func songsList(args []string) {
+	fs := flag.NewFlagSet("songs list", flag.ExitOnError)
+	pageNumber := fs.Int("page", 1, "page to show, starting at 1")
+	limit := fs.Int("limit", 50, "songs per page")
+	sort := fs.String("sort", "id", "sort by "+strings.Join(db.SONG_SORT_KEYS, ", "))
+	desc := fs.Bool("desc", false, "sort in descending order")
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'songs list'")
+	}
+	if *pageNumber < 1 || *limit < 1 {
+		log.Logger.Fatal("-page and -limit must be at least 1")
+	}
+
+	client := openSongsClient()
+	defer client.Close()
+
+	total, err := client.TotalSongs()
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not count songs")
+	}
+	songs, err := client.ListSongs(db.SongPage{Sort: *sort, Desc: *desc, Limit: *limit, Offset: (*pageNumber - 1) * *limit})
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not list songs")
+	}
+
+	printSongs(songs)
+	pages := max(1, (total+*limit-1) / *limit)
+	fmt.Printf("\nPage %d of %d (%d songs)\n", *pageNumber, pages, total)
+}
+
+func songsSearch(args []string) {
+	fs := flag.NewFlagSet("songs search", flag.ExitOnError)
+	limit := fs.Int("limit", 50, "most results to show")
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'songs search'")
+	}
+	text := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if text == "" {
+		log.Logger.Fatal("Missing search text for 'songs search'")
+	}
+
+	client := openSongsClient()
+	defer client.Close()
+
+	songs, err := client.SearchSongs(text, db.SongPage{Sort: "title", Limit: *limit})
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not search songs")
+	}
+	if len(songs) == 0 {
+		fmt.Printf("No songs matching %q\n", text)
+		return
+	}
+	printSongs(songs)
+}
+
+func songsShow(args []string) {
+	songID := songIDArg("songs show", args)
+
+	client := openSongsClient()
+	defer client.Close()
+
+	song := mustGetSong(client, songID)
+	count, err := client.CountFingerprints(songID)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not count fingerprints")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%d\n", song.ID)
+	fmt.Fprintf(tw, "Title\t%s\n", song.Title)
+	fmt.Fprintf(tw, "Artist\t%s\n", song.Artist)
+	fmt.Fprintf(tw, "Artists\t%s\n", strings.Join(song.Artists, ", "))
+	fmt.Fprintf(tw, "Album\t%s\n", song.Album)
+	fmt.Fprintf(tw, "Duration\t%s\n", formatDuration(song.Duration))
+	fmt.Fprintf(tw, "Spotify ID\t%s\n", song.SpotifyID)
+	fmt.Fprintf(tw, "YouTube ID\t%s\n", song.YouTubeID)
+	fmt.Fprintf(tw, "Source\t%s\n", song.SourceURL)
+	fmt.Fprintf(tw, "Ingested\t%s\n", formatTime(song.IngestedAt))
+	fmt.Fprintf(tw, "Fingerprints\t%d\n", count)
+	tw.Flush()
+
+	aliases, err := client.GetAliases(songID)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not get aliases")
+	}
+	if len(aliases) == 0 {
+		return
+	}
+	fmt.Println("\nAlso added as ->")
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, a := range aliases {
+		fmt.Fprintf(tw, "\t%s\t%s\t%s\t%s\n", a.Title, a.Artist, a.SourceURL, formatTime(a.CreatedAt))
+	}
+	tw.Flush()
+}
+
+func songsRm(args []string) {
+	fs := flag.NewFlagSet("songs rm", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'songs rm'")
+	}
+	songID := songIDArg("songs rm", fs.Args())
+
+	client := openSongsClient()
+	defer client.Close()
+
+	song := mustGetSong(client, songID)
+	count, err := client.CountFingerprints(songID)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not count fingerprints")
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Delete %q by %s and its %d fingerprints?", song.Title, song.Artist, count)) {
+		fmt.Println("Nothing deleted")
+		return
+	}
+
+	if err := client.DeleteSongByID(songID); err != nil {
+		log.Logger.WithError(err).Fatal("Could not delete song")
+	}
+	fmt.Printf("Deleted %q by %s\n", song.Title, song.Artist)
+}
+
+func openSongsClient() db.DbClient {
+	client, err := db.NewDbClient()
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not connect to the database")
+	}
+	return client
+}
+
+func songIDArg(command string, args []string) uint32 {
+	if len(args) < 1 {
+		log.Logger.Fatalf("Missing song ID for '%s' (see 'songs list' or 'songs search')", command)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		log.Logger.Fatalf("Invalid song ID %q for '%s'", args[0], command)
+	}
+	return uint32(id)
+}
+
+func mustGetSong(client db.DbClient, songID uint32) db.Song {
+	song, found, err := client.GetSongByID(songID)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not get song")
+	}
+	if !found {
+		log.Logger.Fatalf("No song with ID %d", songID)
+	}
+	return song
+}
+
+func printSongs(songs []db.Song) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tARTIST\tALBUM\tDURATION\tINGESTED")
+	for _, s := range songs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Title, s.Artist, s.Album, formatDuration(s.Duration), formatTime(s.IngestedAt))
+	}
+	tw.Flush()
+}
+
+// m:ss, "-" when unknown
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+// y/N on stdin
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/match"
+)
+
+/*
+stats [-top 10] [-sparse 0.25] [-stop-list N]
+
+scans the whole fingerprints table, it takes a while on a big index
+*/
+func statsCommand(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	top := fs.Int("top", 10, "how many of the most frequent hashes and sparsest songs to show")
+	sparse := fs.Float64("sparse", 0.25, "flag songs with fewer hashes per second than this fraction of the average")
+	stopList := fs.Int("stop-list", match.DefaultOptions().MaxAddressSongs, "show the stop-list for hashes in more than this many songs")
+	if err := fs.Parse(args); err != nil {
+		log.Logger.WithError(err).Fatal("Invalid flags for 'stats'")
+	}
+
+	client := openSongsClient()
+	defer client.Close()
+
+	summary, err := client.IndexSummary()
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not summarise the index")
+	}
+	fmt.Println("Index ->")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\tSongs\t%d\n", summary.Songs)
+	fmt.Fprintf(tw, "\tFingerprints\t%d\n", summary.Fingerprints)
+	fmt.Fprintf(tw, "\tDistinct hashes\t%d\n", summary.DistinctAddresses)
+	fmt.Fprintf(tw, "\tAudio\t%s\n", summary.TotalDuration.Round(time.Second))
+	fmt.Fprintf(tw, "\tHashes per second\t%.1f\n", summary.HashesPerSecond)
+	tw.Flush()
+
+	addresses, err := client.TopAddresses(*top)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not get the most frequent hashes")
+	}
+	fmt.Println("\nMost frequent hashes ->")
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tHASH\tCOUPLES\tSONGS")
+	for _, a := range addresses {
+		fmt.Fprintf(tw, "\t%08x\t%d\t%d\n", a.Address, a.Couples, a.Songs)
+	}
+	tw.Flush()
+
+	fmt.Println("\nStop-list ->")
+	if *stopList > 0 {
+		printStopList(client, *stopList, *top, summary)
+	} else {
+		fmt.Println("\toff")
+	}
+
+	buckets, err := client.AddressFill()
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not get the hash fill distribution")
+	}
+	fmt.Println("\nCouples per hash ->")
+	printFill(buckets)
+
+	sizes, err := client.RelationSizes()
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not get table sizes")
+	}
+	fmt.Println("\nStorage ->")
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tNAME\tKIND\tSIZE\tTOTAL\tROWS (EST.)")
+	for _, s := range sizes {
+		kind, rows := "table", fmt.Sprint(s.Rows)
+		if s.Index {
+			kind = "index"
+		}
+		if s.Rows < 0 {
+			rows = "-"
+		}
+		fmt.Fprintf(tw, "\t%s\t%s\t%s\t%s\t%s\n", s.Name, kind, formatBytes(s.Bytes), formatBytes(s.TotalBytes), rows)
+	}
+	tw.Flush()
+
+	threshold := *sparse * summary.HashesPerSecond
+	songs, err := client.SparseSongs(threshold, *top)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not get sparse songs")
+	}
+	fmt.Printf("\nSongs with under %.1f hashes per second ->\n", threshold)
+	if len(songs) == 0 {
+		fmt.Println("\tnone")
+		return
+	}
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tID\tTITLE\tARTIST\tDURATION\tFINGERPRINTS\tPER SECOND")
+	for _, s := range songs {
+		fmt.Fprintf(tw, "\t%d\t%s\t%s\t%s\t%d\t%.1f\n", s.ID, s.Title, s.Artist, formatDuration(s.Duration), s.Fingerprints, s.HashesPerSecond)
+	}
+	tw.Flush()
+	fmt.Println("\nRe-add them (after 'songs rm'), or 'db gc' for the ones without any fingerprints")
+}
+
+func printStopList(client db.DbClient, maxSongs int, top int, summary db.IndexSummary) {
+	list, err := client.StopList(maxSongs, top)
+	if err != nil {
+		log.Logger.WithError(err).Fatal("Could not get the stop-list")
+	}
+
+	share := 0.0
+	if summary.Fingerprints > 0 {
+		share = 100 * float64(list.Couples) / float64(summary.Fingerprints)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\tHashes in more than\t%d songs\n", list.MaxSongs)
+	fmt.Fprintf(tw, "\tStop-listed hashes\t%d\n", list.Addresses)
+	fmt.Fprintf(tw, "\tTheir fingerprints\t%d (%.1f%%)\n", list.Couples, share)
+	tw.Flush()
+	if len(list.Top) == 0 {
+		return
+	}
+
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\n\tHASH\tCOUPLES\tSONGS")
+	for _, a := range list.Top {
+		fmt.Fprintf(tw, "\t%08x\t%d\t%d\n", a.Address, a.Couples, a.Songs)
+	}
+	tw.Flush()
+}
+
+// a bar per bucket, scaled to the biggest one
+func printFill(buckets []db.FillBucket) {
+	var largest int64
+	for _, b := range buckets {
+		largest = max(largest, b.Addresses)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, b := range buckets {
+		label := fmt.Sprintf("%d-%d", b.Min, b.Max)
+		if b.Min == b.Max {
+			label = fmt.Sprint(b.Min)
+		}
+		bar := ""
+		if largest > 0 {
+			bar = strings.Repeat("#", int(max(1, 40*b.Addresses/largest)))
+		}
+		fmt.Fprintf(tw, "\t%s\t%d\t%s\n", label, b.Addresses, bar)
+	}
+	tw.Flush()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,113 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+)
+
+// the sample rate the whole fingerprinting pipeline expects (same as the ffmpeg conversion)
+const TARGET_SAMPLE_RATE = 44100
+
+// average interleaved frames down to a single channel
+func Downmix(samples []float64, channels int) ([]float64, error) {
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid channel count: %d", channels)
+	}
+	if channels == 1 {
+		return samples, nil
+	}
+
+	frames := len(samples) / channels
+	mono := make([]float64, frames)
+	for i := 0; i < frames; i++ {
+		sum := 0.0
+		for c := 0; c < channels; c++ {
+			sum += samples[i*channels+c]
+		}
+		mono[i] = sum / float64(channels)
+	}
+
+	return mono, nil
+}
+
+const (
+	resampleZeroCrossings = 16  // sinc lobes on each side of a sample, more is sharper and slower
+	resampleTableRes      = 512 // kernel table entries per lobe
+)
+
+// Blackman windowed sinc from 0 to resampleZeroCrossings, looked up instead of calling math.Sin per tap
+var resampleKernel = func() []float64 {
+	kernel := make([]float64, resampleZeroCrossings*resampleTableRes+2)
+	for i := range kernel {
+		x := float64(i) / resampleTableRes
+		if x > resampleZeroCrossings {
+			break
+		}
+		sinc := 1.0
+		if x > 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		t := 0.5 + x/(2*resampleZeroCrossings) // window position, 0.5 at the centre
+		window := 0.42 - 0.5*math.Cos(2*math.Pi*t) + 0.08*math.Cos(4*math.Pi*t)
+		kernel[i] = sinc * window
+	}
+	return kernel
+}()
+
+func kernelAt(x float64) float64 {
+	pos := math.Abs(x) * resampleTableRes
+	idx := int(pos)
+	if idx+1 >= len(resampleKernel) {
+		return 0
+	}
+	frac := pos - float64(idx)
+	return resampleKernel[idx]*(1-frac) + resampleKernel[idx+1]*frac
+}
+
+/*
+band limited (windowed sinc) resampling. Going down in rate the kernel is stretched so it also
+low-passes at the new Nyquist, otherwise everything above it (e.g. 22-48kHz of a 96kHz file)
+folds back into the range the fingerprints look at
+*/
+func Resample(samples []float64, from, to int) ([]float64, error) {
+	if from <= 0 || to <= 0 {
+		return nil, fmt.Errorf("sample rates must be above 0, from: %d | to: %d", from, to)
+	}
+	if from == to || len(samples) == 0 {
+		return samples, nil
+	}
+
+	ratio := float64(to) / float64(from)
+	cutoff := math.Min(1, ratio) // fraction of the input Nyquist that's kept
+	half := resampleZeroCrossings / cutoff
+	size := int(float64(len(samples)) * ratio)
+	output := make([]float64, size)
+
+	for i := range output {
+		pos := float64(i) / ratio
+		lo := max(0, int(math.Ceil(pos-half)))
+		hi := min(len(samples)-1, int(math.Floor(pos+half)))
+
+		var sum, weights float64
+		for j := lo; j <= hi; j++ {
+			w := kernelAt((float64(j) - pos) * cutoff)
+			sum += samples[j] * w
+			weights += w
+		}
+		// normalising by the weights also keeps the gain right at the edges
+		if weights != 0 {
+			output[i] = sum / weights
+		}
+	}
+
+	return output, nil
+}
+
+// downmix to mono and resample to TARGET_SAMPLE_RATE in one go
+func ToMono(samples []float64, channels, sampleRate int) ([]float64, error) {
+	mono, err := Downmix(samples, channels)
+	if err != nil {
+		return nil, err
+	}
+	return Resample(mono, sampleRate, TARGET_SAMPLE_RATE)
+}
@@ -0,0 +1,148 @@
+package audio
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+const (
+	denoiseFrame      = 2048 // ~46ms at 44.1kHz
+	denoiseHop        = denoiseFrame / 4
+	denoiseNoiseShare = 0.1  // quietest fraction of frames the noise is estimated from
+	denoiseFloor      = 0.05 // never take a bin below this fraction of its original magnitude
+)
+
+/*
+spectral subtraction: the noise spectrum is the average of the quietest frames
+(a fan/hum/room tone is in every frame, the music isn't), and gets subtracted from
+the magnitude of every frame. Phase is kept and the frames are overlap-added back.
+
+the floor stops bins from going to 0, which is what makes "musical noise" (random
+isolated peaks) that would end up as bogus fingerprint peaks
+*/
+func SpectralSubtract(samples []float64, strength float64) []float64 {
+	if len(samples) < denoiseFrame*2 {
+		return samples
+	}
+
+	window := make([]float64, denoiseFrame)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/denoiseFrame) // periodic Hann
+	}
+
+	/*
+		every frame's spectrum at once would be 32KiB per hop, hundreds of MB for a whole song,
+		so the spectra are recomputed into one buffer in each pass instead of kept around
+	*/
+	frameCount := (len(samples)-denoiseFrame)/denoiseHop + 1
+	spectrum := make([]complex128, denoiseFrame)
+	energies := make([]float64, frameCount)
+	for f := range energies {
+		windowedSpectrum(spectrum, samples, window, f)
+		for _, bin := range spectrum[:denoiseFrame/2+1] {
+			energies[f] += real(bin)*real(bin) + imag(bin)*imag(bin)
+		}
+	}
+
+	// noise magnitude per bin from the quietest frames
+	order := make([]int, frameCount)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return energies[order[i]] < energies[order[j]] })
+	quiet := max(1, int(float64(frameCount)*denoiseNoiseShare))
+
+	noise := make([]float64, denoiseFrame/2+1)
+	for _, f := range order[:quiet] {
+		windowedSpectrum(spectrum, samples, window, f)
+		for k := range noise {
+			noise[k] += cmplx.Abs(spectrum[k])
+		}
+	}
+	for k := range noise {
+		noise[k] /= float64(quiet)
+	}
+
+	output := make([]float64, len(samples))
+	weights := make([]float64, len(samples))
+	for f := 0; f < frameCount; f++ {
+		windowedSpectrum(spectrum, samples, window, f)
+		for k := 0; k <= denoiseFrame/2; k++ {
+			magnitude := cmplx.Abs(spectrum[k])
+			if magnitude == 0 {
+				continue
+			}
+			cleaned := math.Max(magnitude-strength*noise[k], denoiseFloor*magnitude)
+			spectrum[k] *= complex(cleaned/magnitude, 0)
+			// keep the spectrum conjugate symmetric so the inverse stays real
+			if k > 0 && k < denoiseFrame/2 {
+				spectrum[denoiseFrame-k] = cmplx.Conj(spectrum[k])
+			}
+		}
+		fft(spectrum, true)
+
+		for i := range spectrum {
+			output[f*denoiseHop+i] += real(spectrum[i]) * window[i]
+			weights[f*denoiseHop+i] += window[i] * window[i]
+		}
+	}
+
+	// whatever the frames didn't cover (the tail) is passed through as it was
+	for i := range output {
+		if weights[i] > 1e-3 {
+			output[i] /= weights[i]
+		} else {
+			output[i] = samples[i]
+		}
+	}
+	return output
+}
+
+// the FFT of frame f of samples, windowed, into spectrum
+func windowedSpectrum(spectrum []complex128, samples, window []float64, f int) {
+	for i := range spectrum {
+		spectrum[i] = complex(samples[f*denoiseHop+i]*window[i], 0)
+	}
+	fft(spectrum, false)
+}
+
+// in place iterative radix-2 FFT, len(data) has to be a power of 2. The inverse is scaled by 1/n
+func fft(data []complex128, inverse bool) {
+	n := len(data)
+
+	// bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, sign*2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := data[start+k], w*data[start+k+size/2]
+				data[start+k] = even + odd
+				data[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		for i := range data {
+			data[i] /= complex(float64(n), 0)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	SILENCE_DBFS = -50.0 // 20ms frames quieter than this count as silence
+	CLIP_LEVEL   = 0.999 // |sample| at or above this is clipped (32735 of 32768 for 16-bit)
+
+	diagnosticsFrame = 8192 // ~5Hz bins at 44.1kHz, enough to tell 50Hz hum from 60Hz
+	minDbfs          = -120.0
+)
+
+// how good a capture is, before finding out the hard way that it doesn't match
+type Diagnostics struct {
+	RMSDbfs         float64  `json:"rms_dbfs"`
+	PeakDbfs        float64  `json:"peak_dbfs"`
+	ClippedPercent  float64  `json:"clipped_percent"`
+	SilenceFraction float64  `json:"silence_fraction"`
+	DCOffset        float64  `json:"dc_offset"`
+	NoiseFloorDbfs  float64  `json:"noise_floor_dbfs"`   // level of the quietest frames
+	NoiseFrequency  float64  `json:"noise_frequency_hz"` // strongest tone in the quietest frames, 0 if the noise is broadband
+	Warnings        []string `json:"warnings,omitempty"`
+}
+
+// interleaved samples straight from the capture, before any downmixing or pre-processing
+func Analyze(samples []float64, channels, sampleRate int) Diagnostics {
+	var d Diagnostics
+	if len(samples) == 0 || channels <= 0 || sampleRate <= 0 {
+		d.RMSDbfs, d.PeakDbfs, d.NoiseFloorDbfs = minDbfs, minDbfs, minDbfs
+		d.SilenceFraction = 1
+		d.Warnings = d.warnings()
+		return d
+	}
+
+	var sum, sumSquares, peak float64
+	clipped := 0
+	for _, s := range samples {
+		sum += s
+		sumSquares += s * s
+		peak = math.Max(peak, math.Abs(s))
+		if math.Abs(s) >= CLIP_LEVEL {
+			clipped++
+		}
+	}
+	d.RMSDbfs = dbfs(math.Sqrt(sumSquares / float64(len(samples))))
+	d.PeakDbfs = dbfs(peak)
+	d.ClippedPercent = 100 * float64(clipped) / float64(len(samples))
+	d.DCOffset = sum / float64(len(samples))
+
+	mono, _ := Downmix(samples, channels)
+	mono = append([]float64(nil), mono...)
+	RemoveDC(mono) // reported above, it'd only be mistaken for noise below
+
+	// silence in 20ms frames
+	frame := max(1, sampleRate/50)
+	frames, silent := 0, 0
+	for start := 0; start+frame <= len(mono); start += frame {
+		frames++
+		if dbfs(rms(mono[start:start+frame])) < SILENCE_DBFS {
+			silent++
+		}
+	}
+	if frames > 0 {
+		d.SilenceFraction = float64(silent) / float64(frames)
+	}
+
+	d.NoiseFloorDbfs, d.NoiseFrequency = noiseProfile(mono, sampleRate)
+	d.Warnings = d.warnings()
+	return d
+}
+
+/*
+the quietest 10% of frames are (mostly) whatever is there without the music,
+their level is the noise floor and a strong peak in their spectrum is hum/a fan/...
+*/
+func noiseProfile(mono []float64, sampleRate int) (float64, float64) {
+	count := len(mono) / diagnosticsFrame
+	if count == 0 {
+		return dbfs(rms(mono)), 0
+	}
+
+	type frameLevel struct {
+		start int
+		rms   float64
+	}
+	levels := make([]frameLevel, count)
+	for i := range levels {
+		levels[i] = frameLevel{i * diagnosticsFrame, rms(mono[i*diagnosticsFrame : (i+1)*diagnosticsFrame])}
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].rms < levels[j].rms })
+	quiet := levels[:max(1, count/10)]
+
+	floor := 0.0
+	for _, l := range quiet {
+		floor += l.rms * l.rms
+	}
+	floor = math.Sqrt(floor / float64(len(quiet)))
+	if dbfs(floor) < -90 {
+		// digital silence, there's no noise to speak of
+		return dbfs(floor), 0
+	}
+
+	spectrum := make([]float64, diagnosticsFrame/2)
+	buffer := make([]complex128, diagnosticsFrame)
+	for _, l := range quiet {
+		for i := range buffer {
+			window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/diagnosticsFrame)
+			buffer[i] = complex(mono[l.start+i]*window, 0)
+		}
+		fft(buffer, false)
+		for k := range spectrum {
+			spectrum[k] += math.Hypot(real(buffer[k]), imag(buffer[k]))
+		}
+	}
+
+	binWidth := float64(sampleRate) / diagnosticsFrame
+	lowest := max(1, int(20/binWidth)) // below 20Hz is DC drift, not noise anyone hears
+	best := lowest
+	for k := lowest; k < len(spectrum)-1; k++ {
+		if spectrum[k] > spectrum[best] {
+			best = k
+		}
+	}
+
+	// only a tone if it stands well above the rest of the noise (12dB over the median)
+	sorted := append([]float64(nil), spectrum[lowest:]...)
+	sort.Float64s(sorted)
+	if median := sorted[len(sorted)/2]; median > 0 && spectrum[best] < 4*median {
+		return dbfs(floor), 0
+	}
+
+	// parabolic interpolation between the neighbouring bins for a better estimate than binWidth
+	offset := 0.0
+	if best > 0 && best < len(spectrum)-1 {
+		a, b, c := spectrum[best-1], spectrum[best], spectrum[best+1]
+		if denominator := a - 2*b + c; denominator != 0 {
+			offset = 0.5 * (a - c) / denominator
+		}
+	}
+	return dbfs(floor), (float64(best) + offset) * binWidth
+}
+
+// what to do about it, in the order it matters
+func (d Diagnostics) warnings() []string {
+	var warnings []string
+
+	if d.PeakDbfs <= SILENCE_DBFS {
+		return []string{"Recording is silent, check the capture device ('devices list', -device) and that the mic isn't muted"}
+	}
+	if d.ClippedPercent >= 0.1 {
+		warnings = append(warnings, fmt.Sprintf("%.1f%% of samples are clipped, turn the input gain down or move the mic away from the speaker", d.ClippedPercent))
+	}
+	if d.RMSDbfs < -40 {
+		warnings = append(warnings, fmt.Sprintf("Recording is very quiet (RMS %.0f dBFS), turn the input gain up or move the mic closer to the source", d.RMSDbfs))
+	}
+	if d.SilenceFraction >= 0.5 {
+		warnings = append(warnings, fmt.Sprintf("%.0f%% of the recording is silence, make sure the music plays for the whole recording (or shorten -duration)", 100*d.SilenceFraction))
+	}
+	if math.Abs(d.DCOffset) >= 0.05 {
+		warnings = append(warnings, fmt.Sprintf("DC offset of %.2f, the mic/interface is biased, the 'dc' pre-processing stage removes it", d.DCOffset))
+	}
+
+	/*
+		the quietest frames of continuous music are still music, so broadband/other noise is only
+		called out when it's really close to the music. Hum is a tell of its own and gets more slack
+	*/
+	snr := d.RMSDbfs - d.NoiseFloorDbfs
+	switch {
+	case isHum(d.NoiseFrequency) && snr < 30:
+		warnings = append(warnings, fmt.Sprintf("Mains hum at %.0f Hz, move away from power cables or use -preprocess with a higher highpass (e.g. dc,highpass=%.0f,peak)", d.NoiseFrequency, math.Min(200, d.NoiseFrequency*1.5)))
+	case snr < 12:
+		switch {
+		case d.NoiseFrequency > 0:
+			warnings = append(warnings, fmt.Sprintf("Background noise (strongest around %.0f Hz, a fan, AC, ...) only %.0f dB below the music, try -preprocess dc,highpass=40,denoise,peak", d.NoiseFrequency, snr))
+		default:
+			warnings = append(warnings, fmt.Sprintf("Background noise only %.0f dB below the music, get closer to the source or try -preprocess dc,highpass=40,denoise,peak", snr))
+		}
+	}
+
+	return warnings
+}
+
+// 50/60Hz or one of their first harmonics
+func isHum(frequency float64) bool {
+	for _, mains := range []float64{50, 60} {
+		for harmonic := 1.0; harmonic <= 4; harmonic++ {
+			if math.Abs(frequency-mains*harmonic) <= 3 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func rms(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, s := range samples {
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// floored so silence doesn't end up as -Inf (which JSON can't hold)
+func dbfs(level float64) float64 {
+	if level <= 0 {
+		return minDbfs
+	}
+	return math.Max(minDbfs, 20*math.Log10(level))
+}
@@ -0,0 +1,142 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/ONESHO1/FINDR/backend/internal/wav"
+)
+
+// what ffmpeg writes to stdout: raw little endian float32, mono, TARGET_SAMPLE_RATE
+const FFMPEG_FORMAT = "f32le"
+
+// keep only the end of ffmpeg's stderr, a long running stream can log forever
+const maxStderr = 4096
+
+// FFMPEG_FORMAT as a WAV encoding, for wav.Decode
+var ffmpegFormat = wav.Format{
+	AudioFormat:   wav.FORMAT_IEEE_FLOAT,
+	Channels:      1,
+	SampleRate:    TARGET_SAMPLE_RATE,
+	BitsPerSample: 32,
+}
+
+/*
+decodes anything ffmpeg can read (files, http streams, ...) into raw PCM on a pipe,
+nothing is written to disk. Cancelling ctx kills ffmpeg
+*/
+func FfmpegDecode(ctx context.Context, source string) (*FfmpegReader, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf("ffmpeg is needed to decode %s: %w", source, err)
+	}
+
+	cmd := exec.CommandContext(ctx,
+		"ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-nostdin",
+		"-i", source,
+		"-vn", // ignore cover art
+		"-f", FFMPEG_FORMAT,
+		"-ac", "1",
+		"-ar", fmt.Sprint(TARGET_SAMPLE_RATE),
+		"-", // write to stdout
+	)
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start ffmpeg: %w", err)
+	}
+
+	return &FfmpegReader{ReadCloser: stdout, cmd: cmd, stderr: stderr, ctx: ctx}, nil
+}
+
+// ffmpeg's stdout, closing it also waits for the process
+type FfmpegReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *tailBuffer
+	ctx    context.Context
+}
+
+// ffmpeg's exit error with whatever it had to say about it
+func (f *FfmpegReader) Close() error {
+	_ = f.ReadCloser.Close()
+	err := f.cmd.Wait()
+	if err == nil {
+		return nil
+	}
+	if ctxErr := f.ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("ffmpeg stopped: %w", ctxErr)
+	}
+	return fmt.Errorf("ffmpeg exited with %w: %s", err, strings.TrimSpace(f.stderr.String()))
+}
+
+// the whole file through ffmpeg, as mono samples at TARGET_SAMPLE_RATE
+func loadWithFfmpeg(ctx context.Context, filePath string) ([]float64, error) {
+	reader, err := FfmpegDecode(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []float64
+	block := make([]byte, 1<<16)
+	pending := 0
+	for {
+		n, readErr := reader.Read(block[pending:])
+		n += pending
+		whole := n - n%4
+
+		decoded, err := wav.Decode(ffmpegFormat, block[:whole])
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		samples = append(samples, decoded...)
+
+		// a sample can be split between reads
+		pending = copy(block, block[whole:n])
+
+		if readErr != nil {
+			closeErr := reader.Close()
+			if !errors.Is(readErr, io.EOF) {
+				return nil, readErr
+			}
+			if closeErr != nil {
+				return nil, closeErr
+			}
+			return samples, nil
+		}
+	}
+}
+
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > maxStderr {
+		t.buf = t.buf[len(t.buf)-maxStderr:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
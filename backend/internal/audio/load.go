@@ -0,0 +1,82 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ONESHO1/FINDR/backend/internal/flac"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/wav"
+)
+
+/*
+any audio file -> mono samples at TARGET_SAMPLE_RATE.
+
+WAV and FLAC (checked by their header, not the extension) are decoded, downmixed and resampled
+in memory, ffmpeg is only needed for other containers (m4a, mp3, ...)
+*/
+func Load(filePath string) ([]float64, error) {
+	return LoadContext(context.Background(), filePath)
+}
+
+// Load, with ctx to time out or cancel an ffmpeg decode
+func LoadContext(ctx context.Context, filePath string) ([]float64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(12)
+	if err == nil && bytes.Equal(magic[:4], []byte("RIFF")) && bytes.Equal(magic[8:], []byte("WAVE")) {
+		return loadWav(reader, filePath)
+	}
+	// FLAC can have an ID3 tag in front, but so can mp3
+	if err == nil && (bytes.Equal(magic[:4], []byte("fLaC")) ||
+		(bytes.Equal(magic[:3], []byte("ID3")) && strings.EqualFold(filepath.Ext(filePath), ".flac"))) {
+		return loadFlac(reader, filePath)
+	}
+
+	file.Close()
+	return loadWithFfmpeg(ctx, filePath)
+}
+
+func loadWav(reader *bufio.Reader, filePath string) ([]float64, error) {
+	decoder, err := wav.NewDecoder(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading WAV header of %s: %w", filePath, err)
+	}
+
+	samples, err := decoder.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", filePath, err)
+	}
+
+	format := decoder.Format()
+	log.Logger.WithField("file", filePath).Debugf("Loaded WAV, %d channels at %d Hz, %d bits", format.Channels, format.SampleRate, format.BitsPerSample)
+
+	return ToMono(samples, format.Channels, format.SampleRate)
+}
+
+func loadFlac(reader *bufio.Reader, filePath string) ([]float64, error) {
+	decoder, err := flac.NewDecoder(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading FLAC metadata of %s: %w", filePath, err)
+	}
+
+	samples, err := decoder.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", filePath, err)
+	}
+
+	info := decoder.Info()
+	log.Logger.WithField("file", filePath).Debugf("Loaded FLAC, %d channels at %d Hz, %d bits", info.Channels, info.SampleRate, info.BitsPerSample)
+
+	return ToMono(samples, info.Channels, info.SampleRate)
+}
@@ -0,0 +1,256 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+/*
+default chains, as specs for ParseChain. Both are off, a query chain changes what every
+lookup matches so it's opt in. Songs are indexed as they are, changing that means re-adding everything
+*/
+const (
+	DEFAULT_QUERY_CHAIN = "none"
+	DEFAULT_INDEX_CHAIN = "none"
+)
+
+// one pre-processing step, Value is the stage's parameter (its default if it wasn't given)
+type Stage struct {
+	Name  string
+	Value float64
+}
+
+// stages run in order on mono samples before the spectrogram
+type Chain []Stage
+
+// stage -> default parameter
+var stageDefaults = map[string]float64{
+	"dc":       0,   // remove the DC offset
+	"highpass": 40,  // cutoff in Hz
+	"denoise":  1,   // spectral subtraction strength, 1 subtracts the noise estimate once
+	"peak":     0.9, // normalise so the loudest sample is at this level
+	"rms":      -20, // normalise the RMS level to this many dBFS
+}
+
+/*
+"dc,highpass=60,denoise=1.5,rms=-18" -> Chain, "none" (or "") is an empty chain
+*/
+func ParseChain(spec string) (Chain, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "none" {
+		return Chain{}, nil
+	}
+
+	var chain Chain
+	for _, part := range strings.Split(spec, ",") {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		defaultValue, ok := stageDefaults[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pre-processing stage %q, expected one of %s", name, stageNames())
+		}
+
+		stage := Stage{Name: name, Value: defaultValue}
+		if hasValue {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+			}
+			stage.Value = parsed
+		}
+		if err := stage.validate(); err != nil {
+			return nil, err
+		}
+		chain = append(chain, stage)
+	}
+	return chain, nil
+}
+
+func stageNames() string {
+	names := make([]string, 0, len(stageDefaults))
+	for name := range stageDefaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (s Stage) validate() error {
+	switch s.Name {
+	case "highpass":
+		if s.Value <= 0 {
+			return fmt.Errorf("highpass cutoff must be above 0 Hz, got %g", s.Value)
+		}
+	case "denoise":
+		if s.Value <= 0 {
+			return fmt.Errorf("denoise strength must be above 0, got %g", s.Value)
+		}
+	case "peak":
+		if s.Value <= 0 || s.Value > 1 {
+			return fmt.Errorf("peak level must be in (0, 1], got %g", s.Value)
+		}
+	case "rms":
+		if s.Value >= 0 {
+			return fmt.Errorf("rms level must be below 0 dBFS, got %g", s.Value)
+		}
+	}
+	return nil
+}
+
+// back to a spec ParseChain understands
+func (c Chain) String() string {
+	if len(c) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(c))
+	for i, stage := range c {
+		parts[i] = stage.Name
+		if stage.Name != "dc" {
+			parts[i] += "=" + strconv.FormatFloat(stage.Value, 'g', -1, 64)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// runs every stage, samples itself is left alone
+func (c Chain) Apply(samples []float64, sampleRate int) []float64 {
+	if len(c) == 0 || len(samples) == 0 {
+		return samples
+	}
+
+	output := make([]float64, len(samples))
+	copy(output, samples)
+
+	for _, stage := range c {
+		switch stage.Name {
+		case "dc":
+			RemoveDC(output)
+		case "highpass":
+			HighPass(output, sampleRate, stage.Value)
+		case "denoise":
+			output = SpectralSubtract(output, stage.Value)
+		case "peak":
+			NormalizePeak(output, stage.Value)
+		case "rms":
+			NormalizeRMS(output, stage.Value)
+		}
+	}
+	return output
+}
+
+// env is read (and a broken chain warned about) once per run
+var (
+	queryChain = sync.OnceValues(func() (Chain, error) {
+		return chainFromEnv("FINDR_QUERY_PREPROCESS", DEFAULT_QUERY_CHAIN)
+	})
+	indexChain = sync.OnceValues(func() (Chain, error) {
+		return chainFromEnv("FINDR_INDEX_PREPROCESS", DEFAULT_INDEX_CHAIN)
+	})
+)
+
+// the chain from env (FINDR_QUERY_PREPROCESS / FINDR_INDEX_PREPROCESS), or the default when it's unset or broken
+func QueryChain() (Chain, error) {
+	chain, err := queryChain()
+	return slices.Clone(chain), err
+}
+
+func IndexChain() (Chain, error) {
+	chain, err := indexChain()
+	return slices.Clone(chain), err
+}
+
+func chainFromEnv(key, fallback string) (Chain, error) {
+	spec, ok := os.LookupEnv(key)
+	if ok {
+		chain, err := ParseChain(spec)
+		if err == nil {
+			return chain, nil
+		}
+		log.Logger.WithError(err).WithField("env", key).Warn("Invalid pre-processing chain, using the default")
+	}
+
+	chain, err := ParseChain(fallback)
+	if err != nil {
+		return nil, fmt.Errorf("default pre-processing chain %q: %w", fallback, err)
+	}
+	return chain, nil
+}
+
+// subtract the mean, a mic with an offset otherwise puts energy in the lowest bins
+func RemoveDC(samples []float64) {
+	if len(samples) == 0 {
+		return
+	}
+	mean := 0.0
+	for _, s := range samples {
+		mean += s
+	}
+	mean /= float64(len(samples))
+	for i := range samples {
+		samples[i] -= mean
+	}
+}
+
+// 2nd order Butterworth high-pass (RBJ cookbook biquad), takes out rumble and handling noise
+func HighPass(samples []float64, sampleRate int, cutoff float64) {
+	if cutoff <= 0 || cutoff >= float64(sampleRate)/2 {
+		return
+	}
+
+	w0 := 2 * math.Pi * cutoff / float64(sampleRate)
+	alpha := math.Sin(w0) / math.Sqrt2 // sin(w0) / 2Q, with Q = 1/sqrt(2)
+	cos := math.Cos(w0)
+
+	a0 := 1 + alpha
+	b0 := (1 + cos) / 2 / a0
+	b1 := -(1 + cos) / a0
+	b2 := (1 + cos) / 2 / a0
+	a1 := -2 * cos / a0
+	a2 := (1 - alpha) / a0
+
+	var x1, x2, y1, y2 float64
+	for i, x := range samples {
+		y := b0*x + b1*x1 + b2*x2 - a1*y1 - a2*y2
+		x2, x1 = x1, x
+		y2, y1 = y1, y
+		samples[i] = y
+	}
+}
+
+func NormalizePeak(samples []float64, level float64) {
+	peak := 0.0
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	if peak == 0 {
+		return
+	}
+	scale(samples, level/peak)
+}
+
+func NormalizeRMS(samples []float64, dbfs float64) {
+	sum := 0.0
+	for _, s := range samples {
+		sum += s * s
+	}
+	if sum == 0 {
+		return
+	}
+	rms := math.Sqrt(sum / float64(len(samples)))
+	scale(samples, math.Pow(10, dbfs/20)/rms)
+}
+
+func scale(samples []float64, gain float64) {
+	for i := range samples {
+		samples[i] *= gain
+	}
+}
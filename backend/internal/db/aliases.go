@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/utils"
+)
+
+// another title/artist (or upload) of an indexed song, without fingerprints of its own
+type Alias struct {
+	SongID    uint32
+	Title     string
+	Artist    string
+	SpotifyID string
+	YouTubeID string
+	SourceURL string
+	CreatedAt time.Time
+}
+
+// records alias as another name of songID, an alias that's already there is left as is
+func (c *PostgresClient) AddAlias(songID uint32, alias Song) error {
+	_, err := c.db.Exec(`
+		INSERT INTO song_aliases (song_id, title, artist, key, spotify_id, youtube_id, source_url)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (key) DO NOTHING
+	`, songID, alias.Title, alias.Artist, utils.GenerateSongKey(alias.Title, alias.Artist), alias.SpotifyID, alias.YouTubeID, alias.SourceURL)
+	if err != nil {
+		return fmt.Errorf("failed to add alias: %w", err)
+	}
+	return nil
+}
+
+func (c *PostgresClient) GetAliases(songID uint32) ([]Alias, error) {
+	rows, err := c.db.Query(`
+		SELECT song_id, title, artist, spotify_id, youtube_id, source_url, created_at
+		FROM song_aliases
+		WHERE song_id = $1
+		ORDER BY id
+	`, songID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying aliases : %w", err)
+	}
+	defer rows.Close()
+
+	var aliases []Alias
+	for rows.Next() {
+		var a Alias
+		if err := rows.Scan(&a.SongID, &a.Title, &a.Artist, &a.SpotifyID, &a.YouTubeID, &a.SourceURL, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// every fingerprint of one song, by anchor time
+func (c *PostgresClient) SongFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT address, anchorTime, songID FROM fingerprints WHERE songID = $1 ORDER BY anchorTime", songID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying fingerprints : %w", err)
+	}
+	defer rows.Close()
+
+	var fingerprints []Fingerprint
+	for rows.Next() {
+		var fp Fingerprint
+		if err := rows.Scan(&fp.Address, &fp.AnchorTimeMs, &fp.SongID); err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	return fingerprints, rows.Err()
+}
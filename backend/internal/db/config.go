@@ -0,0 +1,256 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/ONESHO1/FINDR/backend/internal/config"
+)
+
+const (
+	DEFAULT_HOST               = "localhost"
+	DEFAULT_PORT               = 5432
+	DEFAULT_MAX_OPEN_CONNS     = 10
+	DEFAULT_MAX_IDLE_CONNS     = 5
+	DEFAULT_CONN_MAX_LIFETIME  = 30 * time.Minute
+	DEFAULT_CONN_MAX_IDLE_TIME = 5 * time.Minute
+	DEFAULT_CONNECT_TIMEOUT    = 5 * time.Second
+)
+
+/*
+how to reach postgres and how big the pool is. URL (DATABASE_URL) wins over the discrete
+fields, Host can also be a unix socket directory
+*/
+type Config struct {
+	URL      string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Database string
+	SSLMode  string // disable, allow, prefer, require, verify-ca, verify-full | empty leaves pgx's default (prefer)
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	ConnectTimeout  time.Duration
+
+	AutoMigrate bool // apply pending migrations on connect, otherwise refuse to run on an outdated schema
+}
+
+/*
+DATABASE_URL, or POSTGRES_HOST / POSTGRES_PORT / POSTGRES_USERNAME / POSTGRES_PASSWORD /
+POSTGRES_DATABASE_NAME / POSTGRES_SSLMODE, plus POSTGRES_MAX_OPEN_CONNS, POSTGRES_MAX_IDLE_CONNS,
+POSTGRES_CONN_MAX_LIFETIME, POSTGRES_CONN_MAX_IDLE_TIME and POSTGRES_CONNECT_TIMEOUT for either.
+FINDR_AUTO_MIGRATE=false turns off migrating on connect
+*/
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		URL:      config.GetEnv("DATABASE_URL", ""),
+		Host:     config.GetEnv("POSTGRES_HOST", DEFAULT_HOST),
+		Username: config.GetEnv("POSTGRES_USERNAME", ""),
+		Password: config.GetEnv("POSTGRES_PASSWORD", ""),
+		Database: config.GetEnv("POSTGRES_DATABASE_NAME", ""),
+		SSLMode:  config.GetEnv("POSTGRES_SSLMODE", ""),
+	}
+
+	var err error
+	if cfg.Port, err = intFromEnv("POSTGRES_PORT", DEFAULT_PORT); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxOpenConns, err = intFromEnv("POSTGRES_MAX_OPEN_CONNS", DEFAULT_MAX_OPEN_CONNS); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxIdleConns, err = intFromEnv("POSTGRES_MAX_IDLE_CONNS", DEFAULT_MAX_IDLE_CONNS); err != nil {
+		return Config{}, err
+	}
+	if cfg.ConnMaxLifetime, err = durationFromEnv("POSTGRES_CONN_MAX_LIFETIME", DEFAULT_CONN_MAX_LIFETIME); err != nil {
+		return Config{}, err
+	}
+	if cfg.ConnMaxIdleTime, err = durationFromEnv("POSTGRES_CONN_MAX_IDLE_TIME", DEFAULT_CONN_MAX_IDLE_TIME); err != nil {
+		return Config{}, err
+	}
+	if cfg.ConnectTimeout, err = durationFromEnv("POSTGRES_CONNECT_TIMEOUT", DEFAULT_CONNECT_TIMEOUT); err != nil {
+		return Config{}, err
+	}
+	if cfg.AutoMigrate, err = boolFromEnv("FINDR_AUTO_MIGRATE", true); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c Config) validate() error {
+	if c.URL != "" {
+		if _, err := pgx.ParseConfig(c.URL); err != nil {
+			return fmt.Errorf("DATABASE_URL is not a valid connection string: %w", err)
+		}
+		return nil
+	}
+
+	var missing []string
+	if c.Username == "" {
+		missing = append(missing, "POSTGRES_USERNAME")
+	}
+	if c.Database == "" {
+		missing = append(missing, "POSTGRES_DATABASE_NAME")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("set DATABASE_URL, or %s (in the environment or .env)", strings.Join(missing, " and "))
+	}
+
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("POSTGRES_PORT must be between 1 and 65535, got %d", c.Port)
+	}
+	switch c.SSLMode {
+	case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		return fmt.Errorf("POSTGRES_SSLMODE must be one of disable, allow, prefer, require, verify-ca, verify-full, got %q", c.SSLMode)
+	}
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		return errors.New("POSTGRES_MAX_OPEN_CONNS and POSTGRES_MAX_IDLE_CONNS can't be negative")
+	}
+	return nil
+}
+
+// the DSN for sql.Open, user and password are escaped so any character works in them
+func (c Config) ConnectionString() string {
+	if c.URL != "" {
+		return c.URL
+	}
+
+	query := url.Values{}
+	if c.SSLMode != "" {
+		query.Set("sslmode", c.SSLMode)
+	}
+	if c.ConnectTimeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(max(1, int(c.ConnectTimeout.Seconds()))))
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		Path:   "/" + c.Database,
+	}
+	if c.Password != "" {
+		dsn.User = url.UserPassword(c.Username, c.Password)
+	} else {
+		// trust/peer auth
+		dsn.User = url.User(c.Username)
+	}
+
+	if strings.HasPrefix(c.Host, "/") {
+		// a socket directory doesn't fit in the authority
+		query.Set("host", c.Host)
+		query.Set("port", strconv.Itoa(c.Port))
+	} else {
+		dsn.Host = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	}
+
+	dsn.RawQuery = query.Encode()
+	return dsn.String()
+}
+
+// where we're connecting to, without the password
+func (c Config) String() string {
+	if c.URL != "" {
+		if parsed, err := url.Parse(c.URL); err == nil && parsed.Scheme != "" {
+			return parsed.Redacted()
+		}
+		return "DATABASE_URL"
+	}
+	return fmt.Sprintf("%s@%s:%d/%s", c.Username, c.Host, c.Port, c.Database)
+}
+
+/*
+pings the database and turns the usual failures into what to fix, instead of a bare driver error
+*/
+func checkHealth(ctx context.Context, ping func(context.Context) error, cfg Config) error {
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_CONNECT_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := ping(ctx)
+	if err == nil {
+		return nil
+	}
+
+	hint := "check the connection settings"
+	var pgErr *pgconn.PgError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &pgErr) && (pgErr.Code == "28P01" || pgErr.Code == "28000"):
+		hint = "authentication failed, check POSTGRES_USERNAME / POSTGRES_PASSWORD (or the user in DATABASE_URL) and pg_hba.conf"
+	case errors.As(err, &pgErr) && pgErr.Code == "3D000":
+		hint = "the database doesn't exist, create it (createdb <name>) or fix POSTGRES_DATABASE_NAME"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		hint = "nothing is listening there, is PostgreSQL running? check POSTGRES_HOST / POSTGRES_PORT"
+	case errors.Is(err, syscall.ECONNRESET):
+		hint = "the connection was dropped, check that it's PostgreSQL listening on POSTGRES_HOST / POSTGRES_PORT and not something else (or a firewall)"
+	case errors.As(err, &dnsErr):
+		hint = "the host name doesn't resolve, check POSTGRES_HOST"
+	case strings.Contains(err.Error(), "server refused TLS connection"):
+		hint = "the server doesn't support SSL, set POSTGRES_SSLMODE=disable (or sslmode=disable in DATABASE_URL)"
+	case strings.Contains(err.Error(), "certificate"):
+		hint = "the server's certificate was rejected, fix the CA or use a less strict POSTGRES_SSLMODE"
+	case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+		hint = fmt.Sprintf("no answer within %s, check the host, port and firewall (or raise POSTGRES_CONNECT_TIMEOUT)", timeout)
+	}
+	return fmt.Errorf("can't reach PostgreSQL at %s, %s: %w", cfg, hint, err)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func intFromEnv(key string, fallback int) (int, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a whole number, got %q", key, value)
+	}
+	return parsed, nil
+}
+
+// "30m", "5s", ... as in time.ParseDuration
+func durationFromEnv(key string, fallback time.Duration) (time.Duration, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like 30s or 5m, got %q", key, value)
+	}
+	return parsed, nil
+}
+
+func boolFromEnv(key string, fallback bool) (bool, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", key, value)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+)
+
+/*
+below this many fingerprints the prepared INSERT is as fast as setting up COPY,
+a song has tens of thousands so ingestion always takes the bulk path
+*/
+const BULK_THRESHOLD = 1000
+
+/*
+runs fn in a pgx transaction on one connection from the pool, for what database/sql can't do (COPY).
+commits when fn returns nil, rolls back otherwise
+*/
+func (c *PostgresClient) withPgxTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting a connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+
+		tx, err := stdlibConn.Conn().Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %w", err)
+		}
+		defer tx.Rollback(ctx) // no-op after a commit
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+/*
+COPY into a temp staging table, then one INSERT ... SELECT that skips the duplicates
+(COPY itself can't do ON CONFLICT). The staging table is dropped with the transaction
+*/
+func copyFingerprints(ctx context.Context, tx pgx.Tx, fingerprints map[uint32]fingerprintalgorithm.Couple) error {
+	_, err := tx.Exec(ctx, `
+		CREATE TEMP TABLE fingerprints_staging (
+			address BIGINT NOT NULL,
+			anchorTime INTEGER NOT NULL,
+			songID BIGINT NOT NULL
+		) ON COMMIT DROP
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating staging table: %w", err)
+	}
+
+	rows := make([][]any, 0, len(fingerprints))
+	for address, couple := range fingerprints {
+		rows = append(rows, []any{int64(address), int32(couple.AnchorTimeMs), int64(couple.SongID)})
+	}
+
+	// unquoted identifiers are folded to lower case by postgres, COPY quotes them
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"fingerprints_staging"},
+		[]string{"address", "anchortime", "songid"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return fmt.Errorf("error copying fingerprints: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO fingerprints (address, anchorTime, songID)
+		SELECT address, anchorTime, songID FROM fingerprints_staging
+		ON CONFLICT (address, anchorTime, songID) DO NOTHING
+	`)
+	if err != nil {
+		return fmt.Errorf("error inserting staged fingerprints: %w", err)
+	}
+	return nil
+}
+
+/*
+registers the song and stores its fingerprints in one transaction, either both end up in the db or
+neither does. The fingerprints' SongID is set to the new song's id, whatever it was before
+*/
+func (c *PostgresClient) StoreSong(song Song, fingerprints map[uint32]fingerprintalgorithm.Couple) (uint32, error) {
+	ctx := context.Background()
+	var songID uint32
+
+	err := c.withPgxTx(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, insertSong, insertSongArgs(song)...).Scan(&songID); err != nil {
+			return registerError(err)
+		}
+
+		owned := make(map[uint32]fingerprintalgorithm.Couple, len(fingerprints))
+		for address, couple := range fingerprints {
+			couple.SongID = songID
+			owned[address] = couple
+		}
+		return copyFingerprints(ctx, tx, owned)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return songID, nil
+}
@@ -1,10 +1,9 @@
 package db
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"time"
 
-	"github.com/ONESHO1/FINDR/backend/internal/config"
 	"github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
 	"github.com/ONESHO1/FINDR/backend/internal/log"
 )
@@ -12,31 +11,109 @@ import (
 type DbClient interface {
 	Close() error
 	StoreFingerprints(fingerprints map[uint32]fingerprintalgorithm.Couple) error
+	StoreSong(song Song, fingerprints map[uint32]fingerprintalgorithm.Couple) (uint32, error)
 	GetCouples(addresses []uint32) (map[uint32][]fingerprintalgorithm.Couple, error)
+	GetCouplesContext(ctx context.Context, addresses []uint32) (map[uint32][]fingerprintalgorithm.Couple, error)
+	FrequentAddresses(ctx context.Context, addresses []uint32, maxSongs int) (map[uint32]int, error)
+	CountSongAddresses(ctx context.Context, songID uint32, maxSongs int) (int, error)
 	TotalSongs() (int, error)
-	RegisterSong(songTitle, songArtist string) (uint32, error)
+	ListSongs(page SongPage) ([]Song, error)
+	SearchSongs(text string, page SongPage) ([]Song, error)
+	CountFingerprints(songID uint32) (int, error)
+	IndexSummary() (IndexSummary, error)
+	TopAddresses(limit int) ([]AddressCount, error)
+	AddressFill() ([]FillBucket, error)
+	RelationSizes() ([]RelationSize, error)
+	SparseSongs(minHashesPerSecond float64, limit int) ([]SongFingerprints, error)
+	StopList(maxSongs int, limit int) (StopList, error)
+	ExportIndex(ctx context.Context, songsFn func(songs []Song) error, fingerprintsFn func(songID uint32, fingerprints []Fingerprint) error) error
+	ImportIndex(ctx context.Context, songs []Song, next func() (Fingerprint, error)) (ImportResult, error)
+	RegisterSong(song Song) (uint32, error)
 	GetSong(filterKey string, value interface{}) (Song, bool, error)
 	GetSongByID(songID uint32) (Song, bool, error)
+	GetSongByIDContext(ctx context.Context, songID uint32) (Song, bool, error)
 	GetSongByKey(key string) (Song, bool, error)
+	SongFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error)
+	AddAlias(songID uint32, alias Song) error
+	GetAliases(songID uint32) ([]Alias, error)
 	DeleteSongByID(songID uint32) error
 	DeleteCollection(collectionName string) error
+	StoreAirplay(event AirplayEvent) error
+	GetAirplay(filter AirplayFilter) ([]AirplayEvent, error)
 }
 
 type Song struct {
-	Title     string
-	Artist    string
-	YouTubeID string
+	ID         uint32
+	Title      string
+	Artist     string   // main artist, the key is made from Title and Artist
+	Artists    []string // everyone credited, main artist first
+	Album      string
+	Duration   time.Duration
+	SpotifyID  string
+	YouTubeID  string
+	SourceURL  string    // spotify link or local file the audio came from
+	IngestedAt time.Time // zero for songs added before it was recorded
 }
 
+// a song being played on a monitored source
+type AirplayEvent struct {
+	Source     string
+	SongID     uint32
+	Title      string // filled in by GetAirplay
+	Artist     string // filled in by GetAirplay
+	Start      time.Time
+	End        time.Time
+	Confidence float64
+	Score      float64
+
+	// where the play is in a file source, whose Start/End are only when it was read
+	InFile    bool
+	FileStart time.Duration
+	FileEnd   time.Duration
+}
+
+// how long the song played for
+func (e AirplayEvent) Length() time.Duration {
+	if e.InFile {
+		return e.FileEnd - e.FileStart
+	}
+	return e.End.Sub(e.Start)
+}
+
+// zero values mean no filter
+type AirplayFilter struct {
+	Source string
+	Since  time.Time
+	Until  time.Time
+}
+
+// connects with the settings from ConfigFromEnv
 func NewDbClient() (DbClient, error) {
-	username := config.GetEnv("POSTGRES_USERNAME", "")
-	password := config.GetEnv("POSTGRES_PASSWORD", "")
-	dbName := config.GetEnv("POSTGRES_DATABASE_NAME", "")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		log.Logger.WithError(err).Error("Invalid database configuration")
+		return nil, err
+	}
+	return NewDbClientWithConfig(cfg)
+}
 
-	if username == "" || password == "" || dbName == "" {
-		err := errors.New("POSTGRES environment variables not set")
-		log.Logger.Error(err)
+// connects and brings the schema up to date (or refuses, see Config.AutoMigrate)
+func NewDbClientWithConfig(cfg Config) (DbClient, error) {
+	client, err := newPostgresClient(cfg)
+	if err != nil {
+		log.Logger.WithError(err).Error("Database health check failed")
 		return nil, err
 	}
-	return newPostgresClient(fmt.Sprintf("postgres://%s:%s@localhost:5432/%s", username, password, dbName))
+	if err := client.ensureSchema(context.Background(), cfg.AutoMigrate); err != nil {
+		client.Close()
+		log.Logger.WithError(err).Error("Database schema check failed")
+		return nil, err
+	}
+	log.Logger.WithField("database", cfg.String()).Debug("Connected to PostgreSQL")
+	return client, nil
+}
+
+// a connection without any schema checks, for 'db migrate' and other maintenance
+func OpenPostgres(cfg Config) (*PostgresClient, error) {
+	return newPostgresClient(cfg)
 }
\ No newline at end of file
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type GcOptions struct {
+	DryRun bool // only count
+	/*
+		also delete songs without fingerprints, left by crashes before ingestion was atomic.
+		Off by default since a silent or very short song legitimately has none
+	*/
+	EmptySongs bool
+}
+
+// what 'db gc' found/did
+type GcResult struct {
+	OrphanFingerprints int64 // fingerprints whose song is gone
+	EmptySongs         int64 // songs without fingerprints, counted always, deleted with GcOptions.EmptySongs
+	Deleted            bool
+	DeletedEmptySongs  bool
+	Validated          bool // fk_fingerprints_song is enforced for existing rows now
+}
+
+const orphanFingerprints = `FROM fingerprints f WHERE NOT EXISTS (SELECT 1 FROM songs s WHERE s.id = f.songID)`
+
+// StoreSong commits a song with its fingerprints, so an ingestion in progress never shows up here
+const emptySongs = `FROM songs s WHERE NOT EXISTS (SELECT 1 FROM fingerprints f WHERE f.songID = s.id)`
+
+/*
+removes fingerprints left behind by songs deleted before fk_fingerprints_song existed, then
+validates the constraint so postgres knows there are none. With opts.EmptySongs, songs without
+any fingerprints go too (ingestion skips them as already added)
+*/
+func (c *PostgresClient) CollectGarbage(ctx context.Context, opts GcOptions) (GcResult, error) {
+	var result GcResult
+
+	err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) "+emptySongs).Scan(&result.EmptySongs)
+	if err != nil {
+		return result, fmt.Errorf("error counting songs without fingerprints: %w", err)
+	}
+
+	if opts.DryRun {
+		err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) "+orphanFingerprints).Scan(&result.OrphanFingerprints)
+		if err != nil {
+			return result, fmt.Errorf("error counting orphan fingerprints: %w", err)
+		}
+		return result, nil
+	}
+
+	res, err := c.db.ExecContext(ctx, "DELETE "+orphanFingerprints)
+	if err != nil {
+		return result, fmt.Errorf("error deleting orphan fingerprints: %w", err)
+	}
+	result.OrphanFingerprints, _ = res.RowsAffected()
+
+	result.Deleted = true
+
+	if opts.EmptySongs {
+		res, err = c.db.ExecContext(ctx, "DELETE "+emptySongs)
+		if err != nil {
+			return result, fmt.Errorf("error deleting songs without fingerprints: %w", err)
+		}
+		result.EmptySongs, _ = res.RowsAffected()
+		result.DeletedEmptySongs = true
+	}
+
+	var validated bool
+	err = c.db.QueryRowContext(ctx, "SELECT convalidated FROM pg_constraint WHERE conname = 'fk_fingerprints_song'").Scan(&validated)
+	if err == sql.ErrNoRows {
+		// not migrated yet, nothing to validate
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("error reading fk_fingerprints_song: %w", err)
+	}
+	if !validated {
+		if _, err := c.db.ExecContext(ctx, "ALTER TABLE fingerprints VALIDATE CONSTRAINT fk_fingerprints_song"); err != nil {
+			return result, fmt.Errorf("error validating fk_fingerprints_song: %w", err)
+		}
+	}
+	result.Validated = true
+	return result, nil
+}
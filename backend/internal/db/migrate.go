@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+/*
+migrations/NNNN_name.sql, applied in order, each in its own transaction. Never edit one that
+has been released, add the next number instead
+*/
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// any constant, so two processes starting at once don't both migrate
+const migrationLock = 0x46494e4452 // "FINDR"
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// the database was migrated by a newer FINDR, running this one against it could break it
+var ErrSchemaTooNew = errors.New("database schema is newer than this build")
+
+// the schema isn't up to date and auto-migration is off
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
+// the embedded migrations, oldest first
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		number, title, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s isn't named NNNN_name.sql", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, name)
+		}
+		seen[version] = name
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: title, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// the version this build expects the database to be at
+func LatestVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+func createSchemaVersionTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_version table: %w", err)
+	}
+	return nil
+}
+
+// version -> when it was applied
+func appliedMigrations(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema_version: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// every known migration and whether it's applied, plus the database's current version
+func (c *PostgresClient) MigrationStatus(ctx context.Context) ([]MigrationStatus, int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := createSchemaVersionTable(ctx, c.db); err != nil {
+		return nil, 0, err
+	}
+	applied, err := appliedMigrations(ctx, c.db)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	current := 0
+	for version := range applied {
+		current = max(current, version)
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		at, ok := applied[migration.Version]
+		statuses[i] = MigrationStatus{Migration: migration, Applied: ok, AppliedAt: at}
+	}
+	return statuses, current, nil
+}
+
+// applies every pending migration in order, returns the ones it applied
+func (c *PostgresClient) MigrateUp(ctx context.Context) ([]Migration, error) {
+	statuses, current, err := c.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := LatestVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > latest {
+		return nil, fmt.Errorf("%w: the database is at version %d, this build only knows up to %d, upgrade FINDR", ErrSchemaTooNew, current, latest)
+	}
+
+	var done []Migration
+	for _, status := range statuses {
+		if status.Applied {
+			continue
+		}
+		applied, err := c.applyMigration(ctx, status.Migration)
+		if err != nil {
+			return done, err
+		}
+		if applied {
+			log.Logger.WithField("version", status.Version).Infof("Applied migration %s", status.Name)
+			done = append(done, status.Migration)
+		}
+	}
+	return done, nil
+}
+
+// false if another process got there first
+func (c *PostgresClient) applyMigration(ctx context.Context, migration Migration) (bool, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// held until the transaction ends
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		return false, fmt.Errorf("error locking for migration: %w", err)
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_version WHERE version = $1)", migration.Version).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error reading schema_version: %w", err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return false, fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_version (version, name) VALUES ($1, $2)", migration.Version, migration.Name); err != nil {
+		return false, fmt.Errorf("error recording migration %d: %w", migration.Version, err)
+	}
+
+	return true, tx.Commit()
+}
+
+/*
+on connect: refuse a newer schema, then migrate (autoMigrate) or refuse an outdated one with
+a pointer to 'db migrate up'
+*/
+func (c *PostgresClient) ensureSchema(ctx context.Context, autoMigrate bool) error {
+	if autoMigrate {
+		_, err := c.MigrateUp(ctx)
+		return err
+	}
+
+	_, current, err := c.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := LatestVersion()
+	if err != nil {
+		return err
+	}
+	switch {
+	case current > latest:
+		return fmt.Errorf("%w: the database is at version %d, this build only knows up to %d, upgrade FINDR", ErrSchemaTooNew, current, latest)
+	case current < latest:
+		return fmt.Errorf("%w: the database is at version %d, this build needs %d, run 'db migrate up'", ErrSchemaOutdated, current, latest)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+-- songs and their fingerprints, IF NOT EXISTS so databases made by the old createTables carry on as version 1
+
+CREATE TABLE IF NOT EXISTS songs (
+	id BIGSERIAL PRIMARY KEY,
+	title TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	key TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS fingerprints (
+	address BIGINT NOT NULL,
+	anchorTime INTEGER NOT NULL,
+	songID BIGINT NOT NULL,
+	PRIMARY KEY (address, anchorTime, songID)
+);
+
+-- lookups are by address alone
+CREATE INDEX IF NOT EXISTS idx_fingerprints ON fingerprints (address);
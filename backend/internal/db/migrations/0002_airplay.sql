@@ -0,0 +1,13 @@
+-- one row per play of an indexed song on a monitored source
+
+CREATE TABLE IF NOT EXISTS airplay (
+	id BIGSERIAL PRIMARY KEY,
+	source TEXT NOT NULL,
+	songID BIGINT NOT NULL,
+	startTime TIMESTAMPTZ NOT NULL,
+	endTime TIMESTAMPTZ NOT NULL,
+	confidence DOUBLE PRECISION NOT NULL,
+	score DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_airplay_start ON airplay (startTime);
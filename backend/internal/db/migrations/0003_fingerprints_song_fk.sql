@@ -0,0 +1,7 @@
+-- deleting a song deletes its fingerprints. NOT VALID so orphans already in the table don't fail the
+-- migration, 'db gc' removes them and validates the constraint. airplay keeps its history on purpose
+
+CREATE INDEX IF NOT EXISTS idx_fingerprints_song ON fingerprints (songID);
+
+ALTER TABLE fingerprints
+	ADD CONSTRAINT fk_fingerprints_song FOREIGN KEY (songID) REFERENCES songs (id) ON DELETE CASCADE NOT VALID;
@@ -0,0 +1,17 @@
+-- everything known about a song besides its fingerprints. Songs from before this keep empty values,
+-- their main artist becomes their artist list and ingested_at stays NULL (unknown)
+
+ALTER TABLE songs
+	ADD COLUMN IF NOT EXISTS album TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS artists TEXT[] NOT NULL DEFAULT '{}',
+	ADD COLUMN IF NOT EXISTS duration_ms INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS spotify_id TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS youtube_id TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS source_url TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS ingested_at TIMESTAMPTZ;
+
+UPDATE songs SET artists = ARRAY[artist] WHERE cardinality(artists) = 0;
+
+ALTER TABLE songs ALTER COLUMN ingested_at SET DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS idx_songs_spotify_id ON songs (spotify_id) WHERE spotify_id <> '';
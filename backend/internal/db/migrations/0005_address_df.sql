@@ -0,0 +1,55 @@
+-- how many songs each address occurs in, for the matcher's stop-list. Kept up to date by statement
+-- level triggers on fingerprints, so bulk inserts and cascaded deletes pay one pass each
+
+CREATE TABLE address_df (
+	address BIGINT PRIMARY KEY,
+	songs INTEGER NOT NULL
+);
+
+INSERT INTO address_df (address, songs)
+SELECT address, COUNT(DISTINCT songID) FROM fingerprints GROUP BY address;
+
+CREATE INDEX idx_address_df_songs ON address_df (songs);
+
+-- a (address, song) pair is new when all of its rows in fingerprints came in with this statement.
+-- ordered so concurrent ingestions lock address_df rows in the same order
+CREATE FUNCTION address_df_insert() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO address_df (address, songs)
+	SELECT p.address, COUNT(*)
+	FROM (SELECT address, songID, COUNT(*) AS n FROM inserted GROUP BY address, songID) p
+	WHERE (SELECT COUNT(*) FROM fingerprints f WHERE f.address = p.address AND f.songID = p.songID) = p.n
+	GROUP BY p.address
+	ORDER BY p.address
+	ON CONFLICT (address) DO UPDATE SET songs = address_df.songs + EXCLUDED.songs;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- and gone when none of its rows are left
+CREATE FUNCTION address_df_delete() RETURNS trigger AS $$
+BEGIN
+	UPDATE address_df d
+	SET songs = d.songs - gone.n
+	FROM (
+		SELECT p.address, COUNT(*) AS n
+		FROM (SELECT DISTINCT address, songID FROM deleted) p
+		WHERE NOT EXISTS (SELECT 1 FROM fingerprints f WHERE f.address = p.address AND f.songID = p.songID)
+		GROUP BY p.address
+	) gone
+	WHERE d.address = gone.address;
+
+	DELETE FROM address_df WHERE songs <= 0;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER fingerprints_df_insert
+	AFTER INSERT ON fingerprints
+	REFERENCING NEW TABLE AS inserted
+	FOR EACH STATEMENT EXECUTE FUNCTION address_df_insert();
+
+CREATE TRIGGER fingerprints_df_delete
+	AFTER DELETE ON fingerprints
+	REFERENCING OLD TABLE AS deleted
+	FOR EACH STATEMENT EXECUTE FUNCTION address_df_delete();
@@ -0,0 +1,16 @@
+-- other names (and uploads) of a song that's already indexed, so adding them again is skipped
+-- before anything gets downloaded. They have no fingerprints of their own
+
+CREATE TABLE song_aliases (
+	id BIGSERIAL PRIMARY KEY,
+	song_id BIGINT NOT NULL REFERENCES songs (id) ON DELETE CASCADE,
+	title TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	key TEXT NOT NULL UNIQUE,
+	spotify_id TEXT NOT NULL DEFAULT '',
+	youtube_id TEXT NOT NULL DEFAULT '',
+	source_url TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_song_aliases_song ON song_aliases (song_id);
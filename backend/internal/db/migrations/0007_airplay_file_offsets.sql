@@ -0,0 +1,6 @@
+-- where in the file a play was, for plays found in files. Those are decoded faster than they'd play,
+-- so their start and end times are only when the monitor read them. NULL for live sources
+
+ALTER TABLE airplay
+	ADD COLUMN IF NOT EXISTS fileStartMs BIGINT,
+	ADD COLUMN IF NOT EXISTS fileEndMs BIGINT;
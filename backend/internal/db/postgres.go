@@ -1,11 +1,16 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ONESHO1/FINDR/backend/internal/utils"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver
 	
 	"github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
@@ -15,69 +20,27 @@ type PostgresClient struct {
 	db *sql.DB
 }
 
-// serves up a new client of type PostgresClient
-func newPostgresClient(connectionString string) (*PostgresClient, error) {
-	db, err := sql.Open("pgx", connectionString)
+// serves up a new client of type PostgresClient, the schema is left alone (see ensureSchema)
+func newPostgresClient(cfg Config) (*PostgresClient, error) {
+	db, err := sql.Open("pgx", cfg.ConnectionString())
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to PostgreSQL: %w", err)
 	}
 
-	if err = db.Ping() ; err != nil {
-		return nil, fmt.Errorf("error pinging PostgreSQL: %w", err)
-	}
+	// the pool, monitor and batch ingestion share one client between goroutines
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	if err = createTables(db); err != nil {
-		return nil, fmt.Errorf("error creating tables: %w", err)
+	if err = checkHealth(context.Background(), db.PingContext, cfg); err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	return &PostgresClient{db: db}, nil
 }
 
-// creates the two tables need for FINDR, also creates an index to imporve fingerprint matching performance
-func createTables(db *sql.DB) (error) {
-	createSongsTable := `
-	CREATE TABLE IF NOT EXISTS songs (
-		id BIGSERIAL PRIMARY KEY,
-		title TEXT NOT NULL,
-		artist TEXT NOT NULL,
-		key TEXT NOT NULL UNIQUE
-	);
-	`
-
-	createFingerprintsTable := `
-	CREATE TABLE IF NOT EXISTS fingerprints (
-		address BIGINT NOT NULL,
-		anchorTime INTEGER NOT NULL,
-		songID BIGINT NOT NULL,
-		PRIMARY KEY (address, anchorTime, songID)
-	)
-	`
-
-	// dont even have to use it anywhere, postgres' query planner gives automatic efficiency gain (read on some website)
-	createFingerprintsIndex := `
-	CREATE INDEX IF NOT EXISTS 
-	idx_fingerprints ON fingerprints (address);
-	`
-
-	_, err := db.Exec(createSongsTable)
-	if err != nil {
-		return fmt.Errorf("error creating songs table : %w", err)
-	}
-
-	_, err = db.Exec(createFingerprintsTable)
-	if err != nil {
-		return fmt.Errorf("error creating fingerprints table : %w", err)
-	}
-
-
-	_, err = db.Exec(createFingerprintsIndex)
-	if err != nil {
-		return fmt.Errorf("error creating fingerprints index: %w", err)
-	}
-
-	return nil
-}
-
 // closes the db connection
 func (c *PostgresClient) Close() (error) {
 	if c.db != nil {
@@ -86,8 +49,15 @@ func (c *PostgresClient) Close() (error) {
 	return nil
 }
 
-// store fingerprints using a transaction (does nothing for duplicates)
+// store fingerprints using a transaction (does nothing for duplicates) | COPY for anything bigger than BULK_THRESHOLD
 func (c *PostgresClient) StoreFingerprints(fingerprints map[uint32]fingerprintalgorithm.Couple) (error) {
+	if len(fingerprints) >= BULK_THRESHOLD {
+		ctx := context.Background()
+		return c.withPgxTx(ctx, func(tx pgx.Tx) error {
+			return copyFingerprints(ctx, tx, fingerprints)
+		})
+	}
+
 	tx, err := c.db.Begin()
 	if err != nil {
 		return fmt.Errorf("error starting transaction: %w", err)
@@ -115,6 +85,11 @@ func (c *PostgresClient) StoreFingerprints(fingerprints map[uint32]fingerprintal
 
 // retrieve couples that match the addresses (hashes) | returns map where key is a hash and the value is a slice of all Couples found for that hash in the database.
 func (c *PostgresClient) GetCouples(addresses []uint32) (map[uint32][]fingerprintalgorithm.Couple, error) {
+	return c.GetCouplesContext(context.Background(), addresses)
+}
+
+// GetCouples, but the query gets cancelled with ctx
+func (c *PostgresClient) GetCouplesContext(ctx context.Context, addresses []uint32) (map[uint32][]fingerprintalgorithm.Couple, error) {
 	couples := make(map[uint32][]fingerprintalgorithm.Couple)
 
 	addrsInt64 := make([]int64, len(addresses))
@@ -123,7 +98,7 @@ func (c *PostgresClient) GetCouples(addresses []uint32) (map[uint32][]fingerprin
 	}
 
 	query := "SELECT address, anchorTime, songID FROM fingerprints WHERE address = ANY($1)"
-	rows, err := c.db.Query(query, addrsInt64)
+	rows, err := c.db.QueryContext(ctx, query, addrsInt64)
 	if err != nil {
 		return nil, fmt.Errorf("error querying database : %w", err)
 	}
@@ -137,6 +112,9 @@ func (c *PostgresClient) GetCouples(addresses []uint32) (map[uint32][]fingerprin
 		}
 		couples[address] = append(couples[address], couple)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading couples : %w", err)
+	}
 
 	return couples, nil
 }
@@ -152,39 +130,81 @@ func (c *PostgresClient) TotalSongs() (int, error) {
 	return count, nil
 }
 
-// register song and return the generated songID
-func (c *PostgresClient) RegisterSong(songTitle, songArtist string) (uint32, error){
-	songKey := utils.GenerateSongKey(songTitle, songArtist)
-
+// register song and return the generated songID | Title and Artist make the key, the rest is whatever is known
+func (c *PostgresClient) RegisterSong(song Song) (uint32, error){
 	var songID uint32
-
-	query := "INSERT INTO songs (title, artist, key) VALUES ($1, $2, $3) RETURNING id"
-	err := c.db.QueryRow(query, songTitle, songArtist, songKey).Scan(&songID)
+	err := c.db.QueryRow(insertSong, insertSongArgs(song)...).Scan(&songID)
 	if err != nil {
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
-			return 0, fmt.Errorf("song with key already exists: %w", err)
-		}
-		return 0, fmt.Errorf("failed to register song: %w", err)
+		return 0, registerError(err)
 	}
 
 	return songID, nil
 }
 
+const insertSong = `
+	INSERT INTO songs (title, artist, key, album, artists, duration_ms, spotify_id, youtube_id, source_url)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id
+`
+
+func insertSongArgs(song Song) []any {
+	artists := song.Artists
+	if len(artists) == 0 {
+		artists = []string{song.Artist}
+	}
+	return []any{
+		song.Title, song.Artist, utils.GenerateSongKey(song.Title, song.Artist), song.Album, artists,
+		song.Duration.Milliseconds(), song.SpotifyID, song.YouTubeID, song.SourceURL,
+	}
+}
+
+func registerError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return fmt.Errorf("song with key already exists: %w", err)
+	}
+	return fmt.Errorf("failed to register song: %w", err)
+}
+
+// the columns scanSong reads, in order
+const songColumns = "id, title, artist, album, artists, duration_ms, spotify_id, youtube_id, source_url, ingested_at"
+
+// for text[], database/sql can't scan arrays on its own
+var pgTypes = pgtype.NewMap()
+
+// songColumns, then whatever else the query selected into extra
+func scanSong(row interface{ Scan(...any) error }, extra ...any) (Song, error) {
+	var song Song
+	var durationMs int64
+	var ingestedAt sql.NullTime
+	dest := []any{
+		&song.ID, &song.Title, &song.Artist, &song.Album, pgTypes.SQLScanner(&song.Artists), &durationMs,
+		&song.SpotifyID, &song.YouTubeID, &song.SourceURL, &ingestedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return Song{}, err
+	}
+	song.Duration = time.Duration(durationMs) * time.Millisecond
+	song.IngestedAt = ingestedAt.Time
+	return song, nil
+}
+
 // A flexible, internal function to retrieve a single song by either its id or its unique key. | interface is the same as any (to make it flexiblt)
 func (c *PostgresClient) GetSong(filterKey string, value interface{}) (Song, bool, error) {
+	return c.getSong(context.Background(), filterKey, value)
+}
+
+func (c *PostgresClient) getSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error) {
 	validFilterKeys := map[string]bool{"id": true, "key": true}
 
 	if !validFilterKeys[filterKey] {
 		return Song{}, false, fmt.Errorf("not a valid filter")
 	}
 
-	query := fmt.Sprintf("SELECT id, title, artist FROM songs WHERE %s = $1", filterKey)
-
-	row := c.db.QueryRow(query, value)
+	query := fmt.Sprintf("SELECT %s FROM songs WHERE %s = $1", songColumns, filterKey)
 
-	var song Song
-	// TODO: Placing the database ID in the song's yt ID section, CHANGE TS
-	err := row.Scan(&song.YouTubeID, &song.Title, &song.Artist)
+	song, err := scanSong(c.db.QueryRowContext(ctx, query, value))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return Song{}, false, nil
@@ -194,18 +214,36 @@ func (c *PostgresClient) GetSong(filterKey string, value interface{}) (Song, boo
 
 	return song, true, nil
 }
- 
+
 // read the function name
 func (c *PostgresClient) GetSongByID(songID uint32) (Song, bool, error) {
 	return c.GetSong("id", songID)
 }
 
-// read the function name
+// GetSongByID, but the query gets cancelled with ctx
+func (c *PostgresClient) GetSongByIDContext(ctx context.Context, songID uint32) (Song, bool, error) {
+	return c.getSong(ctx, "id", songID)
+}
+
+// read the function name | the key of an alias gives the song it's an alias of
 func (db *PostgresClient) GetSongByKey(key string) (Song, bool, error) {
-	return db.GetSong("key", key)
+	song, found, err := db.GetSong("key", key)
+	if err != nil || found {
+		return song, found, err
+	}
+
+	var songID uint32
+	err = db.db.QueryRow("SELECT song_id FROM song_aliases WHERE key = $1", key).Scan(&songID)
+	if err == sql.ErrNoRows {
+		return Song{}, false, nil
+	}
+	if err != nil {
+		return Song{}, false, fmt.Errorf("failed to retrieve alias: %w", err)
+	}
+	return db.GetSongByID(songID)
 }
 
-// delete a song by ID
+// delete a song by ID, its fingerprints go with it (fk_fingerprints_song cascades)
 func (db *PostgresClient) DeleteSongByID(songID uint32) error {
 	_, err := db.db.Exec("DELETE FROM songs WHERE id = $1", songID)
 	if err != nil {
@@ -221,4 +259,66 @@ func (db *PostgresClient) DeleteCollection(collectionName string) error {
 		return fmt.Errorf("error deleting collection: %v", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// log a single play
+func (db *PostgresClient) StoreAirplay(event AirplayEvent) error {
+	query := `
+		INSERT INTO airplay (source, songID, startTime, endTime, confidence, score, fileStartMs, fileEndMs)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	var fileStart, fileEnd *int64
+	if event.InFile {
+		start, end := event.FileStart.Milliseconds(), event.FileEnd.Milliseconds()
+		fileStart, fileEnd = &start, &end
+	}
+	_, err := db.db.Exec(query, event.Source, event.SongID, event.Start, event.End, event.Confidence, event.Score, fileStart, fileEnd)
+	if err != nil {
+		return fmt.Errorf("failed to store airplay event: %w", err)
+	}
+	return nil
+}
+
+// plays that started inside the filter's window, oldest first | song title/artist are joined in (empty if the song was deleted)
+func (db *PostgresClient) GetAirplay(filter AirplayFilter) ([]AirplayEvent, error) {
+	query := `
+		SELECT a.source, a.songID, COALESCE(s.title, ''), COALESCE(s.artist, ''), a.startTime, a.endTime, a.confidence, a.score, a.fileStartMs, a.fileEndMs
+		FROM airplay a
+		LEFT JOIN songs s ON s.id = a.songID
+		WHERE ($1 = '' OR a.source = $1)
+		AND ($2::timestamptz IS NULL OR a.startTime >= $2)
+		AND ($3::timestamptz IS NULL OR a.startTime < $3)
+		ORDER BY a.startTime
+	`
+
+	var since, until *time.Time
+	if !filter.Since.IsZero() {
+		since = &filter.Since
+	}
+	if !filter.Until.IsZero() {
+		until = &filter.Until
+	}
+
+	rows, err := db.db.Query(query, filter.Source, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("error querying airplay : %w", err)
+	}
+	defer rows.Close()
+
+	var events []AirplayEvent
+	for rows.Next() {
+		var event AirplayEvent
+		var fileStart, fileEnd sql.NullInt64
+		if err := rows.Scan(&event.Source, &event.SongID, &event.Title, &event.Artist, &event.Start, &event.End, &event.Confidence, &event.Score, &fileStart, &fileEnd); err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		if fileStart.Valid && fileEnd.Valid {
+			event.InFile = true
+			event.FileStart = time.Duration(fileStart.Int64) * time.Millisecond
+			event.FileEnd = time.Duration(fileEnd.Int64) * time.Millisecond
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// *sql.DB, or a *sql.Tx for reads that have to see one snapshot
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// one page of songs, sorted by one of SONG_SORT_KEYS
+type SongPage struct {
+	Sort   string // empty sorts by id
+	Desc   bool
+	Limit  int // <= 0 means no limit
+	Offset int
+}
+
+// sort key -> column, nothing from the user ends up in the query as is
+var songSortColumns = map[string]string{
+	"id":       "id",
+	"title":    "lower(title)",
+	"artist":   "lower(artist)",
+	"album":    "lower(album)",
+	"duration": "duration_ms",
+	"ingested": "ingested_at",
+}
+
+var SONG_SORT_KEYS = []string{"id", "title", "artist", "album", "duration", "ingested"}
+
+func (p SongPage) orderBy() (string, error) {
+	key := p.Sort
+	if key == "" {
+		key = "id"
+	}
+	column, ok := songSortColumns[key]
+	if !ok {
+		return "", fmt.Errorf("can't sort songs by %q, expected one of %s", p.Sort, strings.Join(SONG_SORT_KEYS, ", "))
+	}
+	direction := "ASC"
+	if p.Desc {
+		direction = "DESC"
+	}
+	// id breaks ties so pages don't overlap
+	return fmt.Sprintf("ORDER BY %s %s NULLS LAST, id %s", column, direction, direction), nil
+}
+
+func (c *PostgresClient) ListSongs(page SongPage) ([]Song, error) {
+	return querySongs(context.Background(), c.db, "", nil, page)
+}
+
+// songs whose title or one of their artists contains text, case insensitive
+func (c *PostgresClient) SearchSongs(text string, page SongPage) ([]Song, error) {
+	// % and _ are literal here
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text) + "%"
+	where := "WHERE title ILIKE $1 OR artist ILIKE $1 OR array_to_string(artists, ' ') ILIKE $1"
+	return querySongs(context.Background(), c.db, where, []any{pattern}, page)
+}
+
+func querySongs(ctx context.Context, q querier, where string, args []any, page SongPage) ([]Song, error) {
+	orderBy, err := page.orderBy()
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM songs %s %s", songColumns, where, orderBy)
+	if page.Limit > 0 {
+		args = append(args, page.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if page.Offset > 0 {
+		args = append(args, page.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying songs : %w", err)
+	}
+	defer rows.Close()
+
+	var songs []Song
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		songs = append(songs, song)
+	}
+	return songs, rows.Err()
+}
+
+// how many fingerprints a song has in the db
+func (c *PostgresClient) CountFingerprints(songID uint32) (int, error) {
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM fingerprints WHERE songID = $1", songID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting fingerprints : %w", err)
+	}
+	return count, nil
+}
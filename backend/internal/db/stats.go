@@ -0,0 +1,174 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// the index at a glance
+type IndexSummary struct {
+	Songs             int
+	Fingerprints      int64
+	DistinctAddresses int64
+	TotalDuration     time.Duration // of the songs whose duration is known
+	HashesPerSecond   float64       // fingerprints per second of audio, over the songs with a known duration
+}
+
+// one hash and how many couples/songs share it
+type AddressCount struct {
+	Address uint32
+	Couples int64
+	Songs   int64
+}
+
+// addresses with Min to Max couples each
+type FillBucket struct {
+	Min, Max  int64
+	Addresses int64
+}
+
+// a table or index and what it takes up on disk
+type RelationSize struct {
+	Name       string
+	Index      bool
+	Bytes      int64 // the relation itself
+	TotalBytes int64 // with its indexes and TOAST for tables, same as Bytes for indexes
+	Rows       int64 // planner estimate, -1 if the table was never analyzed
+}
+
+// a song and how densely it's fingerprinted
+type SongFingerprints struct {
+	Song
+	Fingerprints    int64
+	HashesPerSecond float64 // 0 when the duration is unknown
+}
+
+func (c *PostgresClient) IndexSummary() (IndexSummary, error) {
+	var summary IndexSummary
+	var durationMs, fingerprintsWithDuration int64
+
+	err := c.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM songs),
+			(SELECT COUNT(*) FROM fingerprints),
+			(SELECT COUNT(DISTINCT address) FROM fingerprints),
+			(SELECT COALESCE(SUM(duration_ms), 0) FROM songs WHERE duration_ms > 0),
+			(SELECT COUNT(*) FROM fingerprints f JOIN songs s ON s.id = f.songID WHERE s.duration_ms > 0)
+	`).Scan(&summary.Songs, &summary.Fingerprints, &summary.DistinctAddresses, &durationMs, &fingerprintsWithDuration)
+	if err != nil {
+		return IndexSummary{}, fmt.Errorf("error summarising the index : %w", err)
+	}
+
+	summary.TotalDuration = time.Duration(durationMs) * time.Millisecond
+	if durationMs > 0 {
+		summary.HashesPerSecond = float64(fingerprintsWithDuration) / (float64(durationMs) / 1000)
+	}
+	return summary, nil
+}
+
+// the hashes with the most couples, they're the least useful for telling songs apart
+func (c *PostgresClient) TopAddresses(limit int) ([]AddressCount, error) {
+	rows, err := c.db.Query(`
+		SELECT address, COUNT(*), COUNT(DISTINCT songID)
+		FROM fingerprints
+		GROUP BY address
+		ORDER BY COUNT(*) DESC, address
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying top addresses : %w", err)
+	}
+	defer rows.Close()
+
+	var addresses []AddressCount
+	for rows.Next() {
+		var a AddressCount
+		if err := rows.Scan(&a.Address, &a.Couples, &a.Songs); err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+// how many addresses have 1, 2-3, 4-7, 8-15, ... couples
+func (c *PostgresClient) AddressFill() ([]FillBucket, error) {
+	rows, err := c.db.Query(`
+		SELECT floor(log(2, n) + 0.000001)::int AS bucket, COUNT(*) -- log is numeric, don't let 3.9999 end up one bucket down
+		FROM (SELECT COUNT(*) AS n FROM fingerprints GROUP BY address) per_address
+		GROUP BY bucket
+		ORDER BY bucket
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying address fill : %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []FillBucket
+	for rows.Next() {
+		var bucket int
+		var count int64
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		lower := int64(math.Pow(2, float64(bucket)))
+		buckets = append(buckets, FillBucket{Min: lower, Max: 2*lower - 1, Addresses: count})
+	}
+	return buckets, rows.Err()
+}
+
+// every table and index in the schema, biggest first
+func (c *PostgresClient) RelationSizes() ([]RelationSize, error) {
+	rows, err := c.db.Query(`
+		SELECT c.relname, c.relkind = 'i', pg_relation_size(c.oid), pg_total_relation_size(c.oid), c.reltuples::bigint
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'i')
+		ORDER BY pg_total_relation_size(c.oid) DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying relation sizes : %w", err)
+	}
+	defer rows.Close()
+
+	var sizes []RelationSize
+	for rows.Next() {
+		var size RelationSize
+		if err := rows.Scan(&size.Name, &size.Index, &size.Bytes, &size.TotalBytes, &size.Rows); err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, rows.Err()
+}
+
+/*
+songs with fewer than minHashesPerSecond fingerprints per second of audio (and songs with none),
+sparsest first. Usually silence, a broken download or a decode that went wrong
+*/
+func (c *PostgresClient) SparseSongs(minHashesPerSecond float64, limit int) ([]SongFingerprints, error) {
+	rows, err := c.db.Query(fmt.Sprintf(`
+		SELECT %s, counts.n, COALESCE(counts.n / NULLIF(s.duration_ms / 1000.0, 0), 0)::float8 AS rate
+		FROM songs s
+		LEFT JOIN LATERAL (SELECT COUNT(*) AS n FROM fingerprints f WHERE f.songID = s.id) counts ON true
+		WHERE counts.n = 0 OR (s.duration_ms > 0 AND counts.n / (s.duration_ms / 1000.0) < $1)
+		ORDER BY rate, s.id
+		LIMIT $2
+	`, songColumns), minHashesPerSecond, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying sparse songs : %w", err)
+	}
+	defer rows.Close()
+
+	var songs []SongFingerprints
+	for rows.Next() {
+		var song SongFingerprints
+		song.Song, err = scanSong(rows, &song.Fingerprints, &song.HashesPerSecond)
+		if err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		songs = append(songs, song)
+	}
+	return songs, rows.Err()
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// the addresses in more than MaxSongs songs, and how much of the index they take up
+type StopList struct {
+	MaxSongs  int
+	Addresses int64 // stop-listed addresses
+	Couples   int64 // fingerprints under them
+	Top       []AddressCount
+}
+
+/*
+the ones among addresses that occur in more than maxSongs songs, address -> songs.
+Reads the document frequencies kept in address_df, so it doesn't touch fingerprints
+*/
+func (c *PostgresClient) FrequentAddresses(ctx context.Context, addresses []uint32, maxSongs int) (map[uint32]int, error) {
+	addrsInt64 := make([]int64, len(addresses))
+	for i, v := range addresses {
+		addrsInt64[i] = int64(v)
+	}
+
+	rows, err := c.db.QueryContext(ctx, "SELECT address, songs FROM address_df WHERE address = ANY($1) AND songs > $2", addrsInt64, maxSongs)
+	if err != nil {
+		return nil, fmt.Errorf("error querying address frequencies : %w", err)
+	}
+	defer rows.Close()
+
+	frequent := make(map[uint32]int)
+	for rows.Next() {
+		var address uint32
+		var songs int
+		if err := rows.Scan(&address, &songs); err != nil {
+			return nil, fmt.Errorf("error in scaning row : %w", err)
+		}
+		frequent[address] = songs
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading address frequencies : %w", err)
+	}
+
+	return frequent, nil
+}
+
+/*
+how many distinct addresses a song has that aren't stop-listed at maxSongs (0 for no stop-list),
+the same way a query's hashes are counted once the stop-listed ones are left out
+*/
+func (c *PostgresClient) CountSongAddresses(ctx context.Context, songID uint32, maxSongs int) (int, error) {
+	var count int
+	err := c.db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT f.address)
+		FROM fingerprints f
+		WHERE f.songID = $1
+		AND ($2 <= 0 OR NOT EXISTS (SELECT 1 FROM address_df d WHERE d.address = f.address AND d.songs > $2))
+	`, songID, maxSongs).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting song addresses : %w", err)
+	}
+	return count, nil
+}
+
+// the stop-list at maxSongs, with its limit most widespread addresses
+func (c *PostgresClient) StopList(maxSongs int, limit int) (StopList, error) {
+	list := StopList{MaxSongs: maxSongs}
+
+	err := c.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM((SELECT COUNT(*) FROM fingerprints f WHERE f.address = d.address)), 0)
+		FROM address_df d
+		WHERE d.songs > $1
+	`, maxSongs).Scan(&list.Addresses, &list.Couples)
+	if err != nil {
+		return StopList{}, fmt.Errorf("error summarising the stop-list : %w", err)
+	}
+
+	rows, err := c.db.Query(`
+		SELECT d.address, (SELECT COUNT(*) FROM fingerprints f WHERE f.address = d.address), d.songs
+		FROM address_df d
+		WHERE d.songs > $1
+		ORDER BY d.songs DESC, d.address
+		LIMIT $2
+	`, maxSongs, limit)
+	if err != nil {
+		return StopList{}, fmt.Errorf("error querying the stop-list : %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a AddressCount
+		if err := rows.Scan(&a.Address, &a.Couples, &a.Songs); err != nil {
+			return StopList{}, fmt.Errorf("error in scaning row : %w", err)
+		}
+		list.Top = append(list.Top, a)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// one row of the fingerprints table
+type Fingerprint struct {
+	Address      uint32
+	AnchorTimeMs uint32
+	SongID       uint32
+}
+
+// what ImportIndex did, IDs maps the imported songs' IDs to the ones they got here
+type ImportResult struct {
+	Songs               int
+	SkippedSongs        int // already in the index (same title and artist)
+	Fingerprints        int64
+	SkippedFingerprints int64 // of skipped songs, or of songs that weren't in the import
+	IDs                 map[uint32]uint32
+}
+
+/*
+reads the whole index from one snapshot: songsFn gets every song by ID, then fingerprintsFn
+the fingerprints of every song, one song at a time ordered by songID then anchor time.
+Songs added or removed meanwhile are in neither, orphan fingerprints are left out
+*/
+func (c *PostgresClient) ExportIndex(ctx context.Context, songsFn func(songs []Song) error, fingerprintsFn func(songID uint32, fingerprints []Fingerprint) error) error {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // no-op after a commit
+
+	songs, err := querySongs(ctx, tx, "", nil, SongPage{Sort: "id"})
+	if err != nil {
+		return err
+	}
+	if err := songsFn(songs); err != nil {
+		return err
+	}
+	if err := exportFingerprints(ctx, tx, fingerprintsFn); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func exportFingerprints(ctx context.Context, q querier, fn func(songID uint32, fingerprints []Fingerprint) error) error {
+	rows, err := q.QueryContext(ctx, `
+		SELECT f.address, f.anchorTime, f.songID
+		FROM fingerprints f
+		WHERE EXISTS (SELECT 1 FROM songs s WHERE s.id = f.songID)
+		ORDER BY f.songID, f.anchorTime, f.address
+	`)
+	if err != nil {
+		return fmt.Errorf("error querying fingerprints : %w", err)
+	}
+	defer rows.Close()
+
+	var batch []Fingerprint
+	for rows.Next() {
+		var fp Fingerprint
+		if err := rows.Scan(&fp.Address, &fp.AnchorTimeMs, &fp.SongID); err != nil {
+			return fmt.Errorf("error in scaning row : %w", err)
+		}
+		if len(batch) > 0 && batch[0].SongID != fp.SongID {
+			if err := fn(batch[0].SongID, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		batch = append(batch, fp)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading fingerprints : %w", err)
+	}
+
+	if len(batch) > 0 {
+		return fn(batch[0].SongID, batch)
+	}
+	return nil
+}
+
+// insertSong, but keeps the ingestion time and gives no row back for a key that's already taken
+const importSong = `
+	INSERT INTO songs (title, artist, key, album, artists, duration_ms, spotify_id, youtube_id, source_url, ingested_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10, now()))
+	ON CONFLICT (key) DO NOTHING
+	RETURNING id
+`
+
+/*
+registers songs under new IDs (skipping the ones already in the index) and copies the
+fingerprints next gives back, with their SongID remapped, in one transaction.
+next returns io.EOF after the last fingerprint
+*/
+func (c *PostgresClient) ImportIndex(ctx context.Context, songs []Song, next func() (Fingerprint, error)) (ImportResult, error) {
+	result := ImportResult{IDs: make(map[uint32]uint32, len(songs))}
+
+	seen := make(map[uint32]bool, len(songs))
+
+	err := c.withPgxTx(ctx, func(tx pgx.Tx) error {
+		for _, song := range songs {
+			if seen[song.ID] {
+				return fmt.Errorf("song %d is in the import twice", song.ID)
+			}
+			seen[song.ID] = true
+
+			var ingestedAt *time.Time
+			if !song.IngestedAt.IsZero() {
+				ingestedAt = &song.IngestedAt
+			}
+			args := append(insertSongArgs(song), ingestedAt)
+
+			var songID uint32
+			err := tx.QueryRow(ctx, importSong, args...).Scan(&songID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				result.SkippedSongs++
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to import %q by %s: %w", song.Title, song.Artist, err)
+			}
+			result.IDs[song.ID] = songID
+			result.Songs++
+		}
+
+		copied, err := tx.CopyFrom(ctx,
+			pgx.Identifier{"fingerprints"},
+			[]string{"address", "anchortime", "songid"},
+			pgx.CopyFromFunc(func() ([]any, error) {
+				for {
+					fp, err := next()
+					if err == io.EOF {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					songID, ok := result.IDs[fp.SongID]
+					if !ok {
+						result.SkippedFingerprints++
+						continue
+					}
+					return []any{int64(fp.Address), int32(fp.AnchorTimeMs), int64(songID)}, nil
+				}
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("error copying fingerprints: %w", err)
+		}
+		result.Fingerprints = copied
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
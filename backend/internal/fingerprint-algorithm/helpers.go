@@ -17,6 +17,25 @@ const (
 	targetZoneSize		= 5
 )
 
+// what the hashes depend on, fingerprints made with different ones never match each other
+type Parameters struct {
+	CutOffFrequency  float64
+	DownSampleRatio  int
+	FrequencyBinSize int
+	HopSize          int
+	TargetZoneSize   int
+}
+
+func CurrentParameters() Parameters {
+	return Parameters{
+		CutOffFrequency:  cutOffFrequency,
+		DownSampleRatio:  downSampleRatio,
+		FrequencyBinSize: frequencyBinSize,
+		HopSize:          hopSize,
+		TargetZoneSize:   targetZoneSize,
+	}
+}
+
 type Peak struct {
 	Time float64
 	// Freq complex128
@@ -0,0 +1,138 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+const id3v1Size = 128
+
+// MSB first bit reader, keeping the CRCs of every byte read for the frame checks
+type bitReader struct {
+	r     *bufio.Reader
+	cache uint64 // bits not handed out yet, right aligned
+	n     uint   // how many bits are in cache
+	crc8  uint8
+	crc16 uint16
+}
+
+func newBitReader(r io.Reader) *bitReader {
+	return &bitReader{r: bufio.NewReaderSize(r, 1<<16)}
+}
+
+func (b *bitReader) readByte() (byte, error) {
+	c, err := b.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	b.crc8 = crc8Table[b.crc8^c]
+	b.crc16 = b.crc16<<8 ^ crc16Table[byte(b.crc16>>8)^c]
+	return c, nil
+}
+
+/*
+is all that's left a 128 byte ID3v1 tag. Some taggers append one to FLAC files too,
+after the last frame it's the end of the audio, not a frame that lost its sync
+*/
+func (b *bitReader) atID3v1() bool {
+	if b.n > 0 {
+		return false
+	}
+	tail, err := b.r.Peek(id3v1Size + 1)
+	return len(tail) == id3v1Size && errors.Is(err, io.EOF) && bytes.HasPrefix(tail, []byte("TAG"))
+}
+
+// up to 57 bits
+func (b *bitReader) read(bits uint) (uint64, error) {
+	for b.n < bits {
+		c, err := b.readByte()
+		if err != nil {
+			if err == io.EOF && b.n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		b.cache = b.cache<<8 | uint64(c)
+		b.n += 8
+	}
+	b.n -= bits
+	value := (b.cache >> b.n) & (1<<bits - 1)
+	return value, nil
+}
+
+// two's complement value of the given width
+func (b *bitReader) readSigned(bits uint) (int64, error) {
+	if bits == 0 {
+		return 0, nil
+	}
+	value, err := b.read(bits)
+	if err != nil {
+		return 0, err
+	}
+	return int64(value<<(64-bits)) >> (64 - bits), nil
+}
+
+// zeros up to the next 1
+func (b *bitReader) readUnary() (uint64, error) {
+	var count uint64
+	for {
+		bit, err := b.read(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			return count, nil
+		}
+		count++
+	}
+}
+
+// rice coded residual with parameter k, zigzag decoded
+func (b *bitReader) readRice(k uint) (int64, error) {
+	high, err := b.readUnary()
+	if err != nil {
+		return 0, err
+	}
+	low, err := b.read(k)
+	if err != nil {
+		return 0, err
+	}
+	value := high<<k | low
+	return int64(value>>1) ^ -int64(value&1), nil
+}
+
+// frames end on a byte boundary
+func (b *bitReader) align() {
+	b.n -= b.n % 8
+}
+
+// start checksumming a new frame
+func (b *bitReader) resetCRC() {
+	b.crc8, b.crc16 = 0, 0
+}
+
+var crc8Table, crc16Table = func() ([256]uint8, [256]uint16) {
+	var t8 [256]uint8
+	var t16 [256]uint16
+	for i := 0; i < 256; i++ {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			// polynomials x^8+x^2+x+1 and x^16+x^15+x^2+1
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		t8[i], t16[i] = c8, c16
+	}
+	return t8, t16
+}()
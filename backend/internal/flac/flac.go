@@ -0,0 +1,247 @@
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// most samples ReadAll allocates for before it has decoded any
+const maxPrealloc = 1 << 24
+
+// the STREAMINFO block, every FLAC stream starts with one
+type StreamInfo struct {
+	MinBlockSize  int
+	MaxBlockSize  int
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	TotalSamples  uint64 // per channel, 0 when the encoder didn't know
+}
+
+/*
+decodes a FLAC stream frame by frame into the same interleaved float64s in [-1, 1]
+that wav.Samples gives, so nothing after it has to care where the audio came from
+*/
+type Decoder struct {
+	br   *bitReader
+	info StreamInfo
+
+	// per channel scratch space, reused for every frame
+	channels [][]int64
+}
+
+// reads the metadata, the decoder is left at the first frame
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	br := newBitReader(r)
+
+	magic := make([]byte, 4)
+	for i := range magic {
+		c, err := br.readByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading FLAC signature: %w", err)
+		}
+		magic[i] = c
+	}
+	// some taggers put ID3v2 in front of FLAC too
+	if bytes.Equal(magic[:3], []byte("ID3")) {
+		if err := skipID3(br); err != nil {
+			return nil, err
+		}
+		for i := range magic {
+			c, err := br.readByte()
+			if err != nil {
+				return nil, fmt.Errorf("error reading FLAC signature: %w", err)
+			}
+			magic[i] = c
+		}
+	}
+	if !bytes.Equal(magic, []byte("fLaC")) {
+		return nil, fmt.Errorf("not a FLAC stream (signature: %q)", magic)
+	}
+
+	d := &Decoder{br: br}
+	gotInfo := false
+	for {
+		last, err := br.read(1)
+		if err != nil {
+			return nil, fmt.Errorf("error reading metadata block header: %w", err)
+		}
+		blockType, err := br.read(7)
+		if err != nil {
+			return nil, err
+		}
+		length, err := br.read(24)
+		if err != nil {
+			return nil, err
+		}
+
+		if blockType == 0 {
+			if err := d.readStreamInfo(); err != nil {
+				return nil, err
+			}
+			if length > 34 {
+				if err := skipBytes(br, length-34); err != nil {
+					return nil, err
+				}
+			}
+			gotInfo = true
+		} else if blockType == 127 {
+			return nil, fmt.Errorf("invalid metadata block type 127")
+		} else if err := skipBytes(br, length); err != nil {
+			// PADDING, SEEKTABLE, VORBIS_COMMENT, PICTURE, ... nothing the fingerprints need
+			return nil, err
+		}
+
+		if last == 1 {
+			break
+		}
+	}
+	if !gotInfo {
+		return nil, fmt.Errorf("FLAC stream has no STREAMINFO block")
+	}
+
+	return d, nil
+}
+
+func (d *Decoder) Info() StreamInfo {
+	return d.info
+}
+
+func (d *Decoder) readStreamInfo() error {
+	fields := []uint{16, 16, 24, 24, 20, 3, 5, 36}
+	values := make([]uint64, len(fields))
+	for i, bits := range fields {
+		value, err := d.br.read(bits)
+		if err != nil {
+			return fmt.Errorf("error reading STREAMINFO: %w", err)
+		}
+		values[i] = value
+	}
+	// the MD5 of the decoded audio, not checked
+	if err := skipBytes(d.br, 16); err != nil {
+		return err
+	}
+
+	d.info = StreamInfo{
+		MinBlockSize:  int(values[0]),
+		MaxBlockSize:  int(values[1]),
+		SampleRate:    int(values[4]),
+		Channels:      int(values[5]) + 1,
+		BitsPerSample: int(values[6]) + 1,
+		TotalSamples:  values[7],
+	}
+	if d.info.SampleRate == 0 {
+		return fmt.Errorf("invalid sample rate 0 in STREAMINFO")
+	}
+	return nil
+}
+
+/*
+the next frame as interleaved samples in [-1, 1], io.EOF after the last one.
+A frame that fails its CRC is an error, not silently bad audio
+*/
+func (d *Decoder) ReadFrame() ([]float64, error) {
+	header, err := d.readFrameHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	if cap(d.channels) < header.channels {
+		d.channels = make([][]int64, header.channels)
+	}
+	d.channels = d.channels[:header.channels]
+	for c := range d.channels {
+		if cap(d.channels[c]) < header.blockSize {
+			d.channels[c] = make([]int64, header.blockSize)
+		}
+		d.channels[c] = d.channels[c][:header.blockSize]
+
+		// the side channel carries one extra bit
+		bps := header.bitsPerSample
+		if (header.assignment == leftSide && c == 1) || (header.assignment == sideRight && c == 0) || (header.assignment == midSide && c == 1) {
+			bps++
+		}
+		if err := d.readSubframe(d.channels[c], bps); err != nil {
+			return nil, fmt.Errorf("error decoding subframe %d: %w", c, err)
+		}
+	}
+
+	d.br.align()
+	crc := d.br.crc16
+	footer, err := d.br.read(16)
+	if err != nil {
+		return nil, fmt.Errorf("error reading frame footer: %w", unexpected(err))
+	}
+	if uint16(footer) != crc {
+		return nil, fmt.Errorf("frame CRC mismatch: got %#04x, expected %#04x", crc, footer)
+	}
+
+	decorrelate(d.channels, header.assignment)
+
+	scale := float64(int64(1) << (header.bitsPerSample - 1))
+	output := make([]float64, header.blockSize*header.channels)
+	for i := 0; i < header.blockSize; i++ {
+		for c, samples := range d.channels {
+			output[i*header.channels+c] = float64(samples[i]) / scale
+		}
+	}
+	return output, nil
+}
+
+// every remaining frame, interleaved
+func (d *Decoder) ReadAll() ([]float64, error) {
+	/*
+		TotalSamples is only a hint from the header, a broken or hostile one could ask for
+		terabytes up front, so past maxPrealloc append grows the slice as the frames come in
+	*/
+	var output []float64
+	if d.info.TotalSamples > 0 {
+		output = make([]float64, 0, min(d.info.TotalSamples*uint64(d.info.Channels), maxPrealloc))
+	}
+	for {
+		frame, err := d.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			return output, nil
+		}
+		if err != nil {
+			return output, err
+		}
+		output = append(output, frame...)
+	}
+}
+
+func skipBytes(br *bitReader, n uint64) error {
+	for ; n > 0; n-- {
+		if _, err := br.readByte(); err != nil {
+			return fmt.Errorf("error skipping metadata: %w", unexpected(err))
+		}
+	}
+	return nil
+}
+
+// the ID3v2 size is 4 bytes of 7 bits each, after the rest of the version and the flags
+func skipID3(br *bitReader) error {
+	header := make([]byte, 6)
+	for i := range header {
+		c, err := br.readByte()
+		if err != nil {
+			return fmt.Errorf("error reading ID3 header: %w", unexpected(err))
+		}
+		header[i] = c
+	}
+	size := uint64(header[2])<<21 | uint64(header[3])<<14 | uint64(header[4])<<7 | uint64(header[5])
+	if header[1]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return skipBytes(br, size)
+}
+
+// running out of data in the middle of something is never a clean EOF
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
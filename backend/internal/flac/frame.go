@@ -0,0 +1,380 @@
+package flac
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// how the channels of a frame are stored
+const (
+	independent = iota
+	leftSide
+	sideRight
+	midSide
+)
+
+type frameHeader struct {
+	blockSize     int
+	sampleRate    int
+	channels      int
+	assignment    int
+	bitsPerSample uint
+}
+
+var sampleRates = [...]int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+var sampleSizes = [...]uint{0, 8, 12, 0, 16, 20, 24, 32}
+
+func (d *Decoder) readFrameHeader() (frameHeader, error) {
+	var header frameHeader
+	if d.br.atID3v1() {
+		return header, io.EOF
+	}
+	d.br.resetCRC()
+
+	sync, err := d.br.read(15)
+	if err != nil {
+		// running out of data between frames is the normal end of the stream
+		if errors.Is(err, io.EOF) {
+			return header, io.EOF
+		}
+		return header, err
+	}
+	if sync != 0x7FFC {
+		return header, fmt.Errorf("lost frame sync (got %#x)", sync)
+	}
+
+	// blocking strategy, block size, sample rate, channels, sample size, reserved
+	fields := make([]uint64, 6)
+	for i, bits := range []uint{1, 4, 4, 4, 3, 1} {
+		if fields[i], err = d.br.read(bits); err != nil {
+			return header, unexpected(err)
+		}
+	}
+	blockCode, rateCode, channelCode, sizeCode := fields[1], fields[2], fields[3], fields[4]
+
+	// frame/sample number, only needed for seeking
+	if err := d.skipUTF8(); err != nil {
+		return header, err
+	}
+
+	switch {
+	case blockCode == 0:
+		return header, fmt.Errorf("reserved block size code")
+	case blockCode == 1:
+		header.blockSize = 192
+	case blockCode <= 5:
+		header.blockSize = 576 << (blockCode - 2)
+	case blockCode == 6:
+		size, err := d.br.read(8)
+		if err != nil {
+			return header, unexpected(err)
+		}
+		header.blockSize = int(size) + 1
+	case blockCode == 7:
+		size, err := d.br.read(16)
+		if err != nil {
+			return header, unexpected(err)
+		}
+		header.blockSize = int(size) + 1
+	default:
+		header.blockSize = 256 << (blockCode - 8)
+	}
+
+	switch {
+	case rateCode == 0:
+		header.sampleRate = d.info.SampleRate
+	case rateCode < 12:
+		header.sampleRate = sampleRates[rateCode]
+	case rateCode == 12:
+		rate, err := d.br.read(8)
+		if err != nil {
+			return header, unexpected(err)
+		}
+		header.sampleRate = int(rate) * 1000
+	case rateCode == 13:
+		rate, err := d.br.read(16)
+		if err != nil {
+			return header, unexpected(err)
+		}
+		header.sampleRate = int(rate)
+	case rateCode == 14:
+		rate, err := d.br.read(16)
+		if err != nil {
+			return header, unexpected(err)
+		}
+		header.sampleRate = int(rate) * 10
+	default:
+		return header, fmt.Errorf("invalid sample rate code")
+	}
+
+	switch {
+	case channelCode < 8:
+		header.assignment = independent
+		header.channels = int(channelCode) + 1
+	case channelCode <= 10:
+		header.assignment = int(channelCode) - 7
+		header.channels = 2
+	default:
+		return header, fmt.Errorf("reserved channel assignment %d", channelCode)
+	}
+
+	if sizeCode == 0 {
+		header.bitsPerSample = uint(d.info.BitsPerSample)
+	} else {
+		header.bitsPerSample = sampleSizes[sizeCode]
+	}
+	if header.bitsPerSample == 0 {
+		return header, fmt.Errorf("reserved sample size code")
+	}
+
+	crc := d.br.crc8
+	expected, err := d.br.read(8)
+	if err != nil {
+		return header, unexpected(err)
+	}
+	if uint8(expected) != crc {
+		return header, fmt.Errorf("frame header CRC mismatch: got %#02x, expected %#02x", crc, expected)
+	}
+
+	// a frame can't change the stream's layout, only the block size
+	if header.channels != d.info.Channels || header.sampleRate != d.info.SampleRate {
+		return header, fmt.Errorf("frame has %d channels at %d Hz, stream has %d at %d Hz", header.channels, header.sampleRate, d.info.Channels, d.info.SampleRate)
+	}
+
+	return header, nil
+}
+
+// UTF-8 style variable length number, 1 to 7 bytes
+func (d *Decoder) skipUTF8() error {
+	first, err := d.br.read(8)
+	if err != nil {
+		return unexpected(err)
+	}
+	extra := 0
+	for mask := uint64(0x80); mask > 0 && first&mask != 0; mask >>= 1 {
+		extra++
+	}
+	if extra == 1 || extra > 7 {
+		return fmt.Errorf("invalid frame number encoding")
+	}
+	for ; extra > 1; extra-- {
+		if _, err := d.br.read(8); err != nil {
+			return unexpected(err)
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) readSubframe(samples []int64, bps uint) error {
+	header, err := d.br.read(8)
+	if err != nil {
+		return unexpected(err)
+	}
+	if header&0x80 != 0 {
+		return fmt.Errorf("subframe padding bit set")
+	}
+	kind := header >> 1 & 0x3F
+
+	// low bits that are always 0 get left out and shifted back in at the end
+	var wasted uint
+	if header&1 == 1 {
+		zeros, err := d.br.readUnary()
+		if err != nil {
+			return unexpected(err)
+		}
+		wasted = uint(zeros) + 1
+		if wasted >= bps {
+			return fmt.Errorf("%d wasted bits in a %d bit subframe", wasted, bps)
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case kind == 0:
+		value, err := d.br.readSigned(bps)
+		if err != nil {
+			return unexpected(err)
+		}
+		for i := range samples {
+			samples[i] = value
+		}
+	case kind == 1:
+		for i := range samples {
+			if samples[i], err = d.br.readSigned(bps); err != nil {
+				return unexpected(err)
+			}
+		}
+	case kind >= 8 && kind <= 12:
+		if err := d.readFixed(samples, bps, int(kind-8)); err != nil {
+			return err
+		}
+	case kind >= 32:
+		if err := d.readLPC(samples, bps, int(kind-31)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("reserved subframe type %d", kind)
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) readWarmup(samples []int64, bps uint, order int) error {
+	if order > len(samples) {
+		return fmt.Errorf("predictor order %d is longer than the block (%d)", order, len(samples))
+	}
+	for i := 0; i < order; i++ {
+		value, err := d.br.readSigned(bps)
+		if err != nil {
+			return unexpected(err)
+		}
+		samples[i] = value
+	}
+	return nil
+}
+
+// fixed polynomial predictors, order 0 to 4
+func (d *Decoder) readFixed(samples []int64, bps uint, order int) error {
+	if err := d.readWarmup(samples, bps, order); err != nil {
+		return err
+	}
+	if err := d.readResidual(samples, order); err != nil {
+		return err
+	}
+
+	for i := order; i < len(samples); i++ {
+		switch order {
+		case 1:
+			samples[i] += samples[i-1]
+		case 2:
+			samples[i] += 2*samples[i-1] - samples[i-2]
+		case 3:
+			samples[i] += 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+		case 4:
+			samples[i] += 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) readLPC(samples []int64, bps uint, order int) error {
+	if err := d.readWarmup(samples, bps, order); err != nil {
+		return err
+	}
+
+	precision, err := d.br.read(4)
+	if err != nil {
+		return unexpected(err)
+	}
+	if precision == 15 {
+		return fmt.Errorf("invalid LPC coefficient precision")
+	}
+	shift, err := d.br.readSigned(5)
+	if err != nil {
+		return unexpected(err)
+	}
+	if shift < 0 {
+		return fmt.Errorf("negative LPC shift %d", shift)
+	}
+
+	coefficients := make([]int64, order)
+	for i := range coefficients {
+		if coefficients[i], err = d.br.readSigned(uint(precision) + 1); err != nil {
+			return unexpected(err)
+		}
+	}
+
+	if err := d.readResidual(samples, order); err != nil {
+		return err
+	}
+
+	for i := order; i < len(samples); i++ {
+		var prediction int64
+		for j, c := range coefficients {
+			prediction += c * samples[i-1-j]
+		}
+		samples[i] += prediction >> uint(shift)
+	}
+	return nil
+}
+
+// rice coded prediction errors, written into samples[order:]
+func (d *Decoder) readResidual(samples []int64, order int) error {
+	method, err := d.br.read(2)
+	if err != nil {
+		return unexpected(err)
+	}
+	if method > 1 {
+		return fmt.Errorf("reserved residual coding method %d", method)
+	}
+	paramBits, escape := uint(4), uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+
+	partitionOrder, err := d.br.read(4)
+	if err != nil {
+		return unexpected(err)
+	}
+	partitions := 1 << partitionOrder
+	partitionSize := len(samples) >> partitionOrder
+	if partitionSize<<partitionOrder != len(samples) || partitionSize < order {
+		return fmt.Errorf("block of %d samples can't be split into %d partitions", len(samples), partitions)
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		end := (p + 1) * partitionSize
+
+		param, err := d.br.read(paramBits)
+		if err != nil {
+			return unexpected(err)
+		}
+
+		if param == escape {
+			// unencoded partition
+			bits, err := d.br.read(5)
+			if err != nil {
+				return unexpected(err)
+			}
+			for ; i < end; i++ {
+				if samples[i], err = d.br.readSigned(uint(bits)); err != nil {
+					return unexpected(err)
+				}
+			}
+			continue
+		}
+
+		for ; i < end; i++ {
+			if samples[i], err = d.br.readRice(uint(param)); err != nil {
+				return unexpected(err)
+			}
+		}
+	}
+	return nil
+}
+
+// undo the stereo decorrelation, channels end up as left/right
+func decorrelate(channels [][]int64, assignment int) {
+	if assignment == independent {
+		return
+	}
+	a, b := channels[0], channels[1]
+	for i := range a {
+		switch assignment {
+		case leftSide:
+			b[i] = a[i] - b[i]
+		case sideRight:
+			a[i] = a[i] + b[i]
+		case midSide:
+			mid := a[i]<<1 | b[i]&1
+			a[i], b[i] = (mid+b[i])>>1, (mid-b[i])>>1
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package indexfile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+)
+
+/*
+an index file is MAGIC and the format version (big endian uint16), then a gzip stream of
+
+	header:       created (unix ms), sample rate, fingerprint parameters, index chain, song count
+	songs:        id, title, artist, album, artists, duration (ms), spotify id, youtube id,
+	              source url, ingested at (unix ms, 0 when unknown)
+	fingerprints: runs of one song's fingerprints by anchor time, each a count, the songID,
+	              then (anchor time delta, address) per fingerprint. A count of 0 ends the file
+
+integers are uvarints (varints for times), strings a uvarint length then the bytes,
+addresses 4 bytes little endian since they're all over the place, floats their 8 byte bits.
+Bump FORMAT_VERSION on any change, readers refuse versions they don't know
+*/
+const (
+	MAGIC          = "FINDRIDX"
+	FORMAT_VERSION = 1
+)
+
+// reading a corrupt file shouldn't allocate whatever a garbage length says
+const (
+	maxStringLength = 1 << 16
+	maxCount        = 1 << 28
+)
+
+var ErrNotIndexFile = errors.New("not a FINDR index file")
+
+var ErrUnsupportedVersion = errors.New("unsupported index file version")
+
+type Header struct {
+	Version    int
+	Created    time.Time
+	SampleRate int
+	Params     fingerprintalgorithm.Parameters
+	IndexChain string // pre-processing the songs were fingerprinted with
+	Songs      int
+}
+
+type Writer struct {
+	gz        *gzip.Writer
+	w         *bufio.Writer
+	songsLeft int
+	buf       [binary.MaxVarintLen64]byte
+}
+
+// writes the header, then the header's Songs songs have to come before any fingerprints
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	var prefix [len(MAGIC) + 2]byte
+	copy(prefix[:], MAGIC)
+	binary.BigEndian.PutUint16(prefix[len(MAGIC):], FORMAT_VERSION)
+	if _, err := w.Write(prefix[:]); err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	writer := &Writer{gz: gz, w: bufio.NewWriter(gz), songsLeft: header.Songs}
+
+	writer.putVarint(header.Created.UnixMilli())
+	writer.putUvarint(uint64(header.SampleRate))
+	writer.putFloat(header.Params.CutOffFrequency)
+	writer.putUvarint(uint64(header.Params.DownSampleRatio))
+	writer.putUvarint(uint64(header.Params.FrequencyBinSize))
+	writer.putUvarint(uint64(header.Params.HopSize))
+	writer.putUvarint(uint64(header.Params.TargetZoneSize))
+	writer.putString(header.IndexChain)
+	writer.putUvarint(uint64(header.Songs))
+
+	return writer, nil
+}
+
+func (w *Writer) WriteSong(song db.Song) error {
+	if w.songsLeft <= 0 {
+		return fmt.Errorf("more songs than the header's count")
+	}
+	w.songsLeft--
+
+	w.putUvarint(uint64(song.ID))
+	w.putString(song.Title)
+	w.putString(song.Artist)
+	w.putString(song.Album)
+	w.putUvarint(uint64(len(song.Artists)))
+	for _, artist := range song.Artists {
+		w.putString(artist)
+	}
+	w.putUvarint(uint64(song.Duration.Milliseconds()))
+	w.putString(song.SpotifyID)
+	w.putString(song.YouTubeID)
+	w.putString(song.SourceURL)
+	var ingestedAt int64
+	if !song.IngestedAt.IsZero() {
+		ingestedAt = song.IngestedAt.UnixMilli()
+	}
+	w.putVarint(ingestedAt)
+
+	return w.writeError()
+}
+
+// one song's fingerprints, sorts them by anchor time in place
+func (w *Writer) WriteFingerprints(songID uint32, fingerprints []db.Fingerprint) error {
+	if w.songsLeft > 0 {
+		return fmt.Errorf("fingerprints before all %d songs were written", w.songsLeft)
+	}
+	if len(fingerprints) == 0 {
+		return nil
+	}
+
+	sort.Slice(fingerprints, func(i, j int) bool {
+		return fingerprints[i].AnchorTimeMs < fingerprints[j].AnchorTimeMs
+	})
+
+	w.putUvarint(uint64(len(fingerprints)))
+	w.putUvarint(uint64(songID))
+	var previous uint32
+	for _, fp := range fingerprints {
+		w.putUvarint(uint64(fp.AnchorTimeMs - previous))
+		previous = fp.AnchorTimeMs
+
+		var address [4]byte
+		binary.LittleEndian.PutUint32(address[:], fp.Address)
+		w.w.Write(address[:])
+	}
+
+	return w.writeError()
+}
+
+// ends the file, the underlying writer is still the caller's to close
+func (w *Writer) Close() error {
+	if w.songsLeft > 0 {
+		return fmt.Errorf("%d songs short of the header's count", w.songsLeft)
+	}
+	w.putUvarint(0)
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+func (w *Writer) putUvarint(v uint64) {
+	w.w.Write(w.buf[:binary.PutUvarint(w.buf[:], v)])
+}
+
+func (w *Writer) putVarint(v int64) {
+	w.w.Write(w.buf[:binary.PutVarint(w.buf[:], v)])
+}
+
+func (w *Writer) putFloat(v float64) {
+	binary.LittleEndian.PutUint64(w.buf[:8], math.Float64bits(v))
+	w.w.Write(w.buf[:8])
+}
+
+func (w *Writer) putString(s string) {
+	w.putUvarint(uint64(len(s)))
+	w.w.WriteString(s)
+}
+
+// bufio keeps the first write error and gives it back from every write after
+func (w *Writer) writeError() error {
+	_, err := w.w.Write(nil)
+	return err
+}
+
+type Reader struct {
+	r         *bufio.Reader
+	header    Header
+	songsRead bool
+
+	// the fingerprint run being read
+	left   uint64
+	songID uint32
+	anchor uint32
+	done   bool
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	var prefix [len(MAGIC) + 2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotIndexFile
+		}
+		return nil, err
+	}
+	if string(prefix[:len(MAGIC)]) != MAGIC {
+		return nil, ErrNotIndexFile
+	}
+	version := int(binary.BigEndian.Uint16(prefix[len(MAGIC):]))
+	if version != FORMAT_VERSION {
+		return nil, fmt.Errorf("%w %d, this build reads version %d", ErrUnsupportedVersion, version, FORMAT_VERSION)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing index file: %w", err)
+	}
+	reader := &Reader{r: bufio.NewReader(gz)}
+
+	header := Header{Version: version}
+	created, err := reader.varint()
+	if err != nil {
+		return nil, reader.corrupt(err)
+	}
+	header.Created = time.UnixMilli(created)
+	if header.SampleRate, err = reader.int(); err != nil {
+		return nil, reader.corrupt(err)
+	}
+	if header.Params.CutOffFrequency, err = reader.float(); err != nil {
+		return nil, reader.corrupt(err)
+	}
+	ints := []*int{&header.Params.DownSampleRatio, &header.Params.FrequencyBinSize, &header.Params.HopSize, &header.Params.TargetZoneSize}
+	for _, dest := range ints {
+		if *dest, err = reader.int(); err != nil {
+			return nil, reader.corrupt(err)
+		}
+	}
+	if header.IndexChain, err = reader.string(); err != nil {
+		return nil, reader.corrupt(err)
+	}
+	if header.Songs, err = reader.int(); err != nil {
+		return nil, reader.corrupt(err)
+	}
+
+	reader.header = header
+	return reader, nil
+}
+
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// every song in the file, has to be read before the fingerprints
+func (r *Reader) Songs() ([]db.Song, error) {
+	if r.songsRead {
+		return nil, fmt.Errorf("songs were already read")
+	}
+	r.songsRead = true
+
+	songs := make([]db.Song, 0, min(r.header.Songs, 1<<16))
+	for range r.header.Songs {
+		song, err := r.song()
+		if err != nil {
+			return nil, r.corrupt(err)
+		}
+		songs = append(songs, song)
+	}
+	return songs, nil
+}
+
+func (r *Reader) song() (db.Song, error) {
+	var song db.Song
+	id, err := r.uint32()
+	if err != nil {
+		return db.Song{}, err
+	}
+	song.ID = id
+
+	for _, dest := range []*string{&song.Title, &song.Artist, &song.Album} {
+		if *dest, err = r.string(); err != nil {
+			return db.Song{}, err
+		}
+	}
+	artists, err := r.int()
+	if err != nil {
+		return db.Song{}, err
+	}
+	for range artists {
+		artist, err := r.string()
+		if err != nil {
+			return db.Song{}, err
+		}
+		song.Artists = append(song.Artists, artist)
+	}
+	durationMs, err := r.int()
+	if err != nil {
+		return db.Song{}, err
+	}
+	song.Duration = time.Duration(durationMs) * time.Millisecond
+	for _, dest := range []*string{&song.SpotifyID, &song.YouTubeID, &song.SourceURL} {
+		if *dest, err = r.string(); err != nil {
+			return db.Song{}, err
+		}
+	}
+	ingestedAt, err := r.varint()
+	if err != nil {
+		return db.Song{}, err
+	}
+	if ingestedAt != 0 {
+		song.IngestedAt = time.UnixMilli(ingestedAt)
+	}
+	return song, nil
+}
+
+// the next fingerprint, io.EOF after the last one
+func (r *Reader) Next() (db.Fingerprint, error) {
+	if !r.songsRead {
+		return db.Fingerprint{}, fmt.Errorf("fingerprints read before the songs")
+	}
+	if r.done {
+		return db.Fingerprint{}, io.EOF
+	}
+
+	if r.left == 0 {
+		count, err := r.uvarint()
+		if err != nil {
+			return db.Fingerprint{}, r.corrupt(err)
+		}
+		if count == 0 {
+			// reading past the end is what makes gzip check its checksum
+			if _, err := r.r.ReadByte(); err == nil {
+				return db.Fingerprint{}, r.corrupt(fmt.Errorf("data after the last fingerprint"))
+			} else if err != io.EOF {
+				return db.Fingerprint{}, r.corrupt(err)
+			}
+			r.done = true
+			return db.Fingerprint{}, io.EOF
+		}
+		songID, err := r.uint32()
+		if err != nil {
+			return db.Fingerprint{}, r.corrupt(err)
+		}
+		r.left, r.songID, r.anchor = count, songID, 0
+	}
+
+	delta, err := r.uvarint()
+	if err != nil {
+		return db.Fingerprint{}, r.corrupt(err)
+	}
+	if delta > math.MaxUint32-uint64(r.anchor) {
+		return db.Fingerprint{}, r.corrupt(fmt.Errorf("anchor time out of range"))
+	}
+	r.anchor += uint32(delta)
+
+	var address [4]byte
+	if _, err := io.ReadFull(r.r, address[:]); err != nil {
+		return db.Fingerprint{}, r.corrupt(err)
+	}
+	r.left--
+
+	return db.Fingerprint{Address: binary.LittleEndian.Uint32(address[:]), AnchorTimeMs: r.anchor, SongID: r.songID}, nil
+}
+
+func (r *Reader) corrupt(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("corrupt index file: %w", err)
+}
+
+func (r *Reader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(r.r)
+}
+
+func (r *Reader) varint() (int64, error) {
+	return binary.ReadVarint(r.r)
+}
+
+func (r *Reader) int() (int, error) {
+	v, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v > maxCount {
+		return 0, fmt.Errorf("value %d out of range", v)
+	}
+	return int(v), nil
+}
+
+func (r *Reader) uint32() (uint32, error) {
+	v, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxUint32 {
+		return 0, fmt.Errorf("value %d out of range", v)
+	}
+	return uint32(v), nil
+}
+
+func (r *Reader) float() (float64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+}
+
+func (r *Reader) string() (string, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > maxStringLength {
+		return "", fmt.Errorf("string of %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
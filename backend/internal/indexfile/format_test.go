@@ -0,0 +1,209 @@
+package indexfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+)
+
+var testHeader = Header{
+	Version:    FORMAT_VERSION,
+	Created:    time.UnixMilli(1760000000123),
+	SampleRate: 44100,
+	Params:     fingerprintalgorithm.Parameters{CutOffFrequency: 5000.5, DownSampleRatio: 4, FrequencyBinSize: 1024, HopSize: 256, TargetZoneSize: 5},
+	IndexChain: "dc,highpass=40",
+	Songs:      2,
+}
+
+var testSongs = []db.Song{
+	{
+		ID:         7,
+		Title:      `12" Mix`,
+		Artist:     "Daft Punk",
+		Album:      "Discovery",
+		Artists:    []string{"Daft Punk", "Romanthony"},
+		Duration:   5*time.Minute + 20*time.Second + 5*time.Millisecond,
+		SpotifyID:  "0DiWol3AO6WpXZgp0goxAV",
+		YouTubeID:  "FGBhQbmPwH8",
+		SourceURL:  "https://open.spotify.com/track/0DiWol3AO6WpXZgp0goxAV",
+		IngestedAt: time.UnixMilli(1750000000456),
+	},
+	// no metadata and an unknown ingestion time, like songs added before they were recorded
+	{ID: math.MaxUint32, Title: "Ünïcödé ♫", Artist: "someone"},
+}
+
+// not sorted by anchor time, WriteFingerprints sorts them
+var testFingerprints = map[uint32][]db.Fingerprint{
+	7: {
+		{Address: 0xFFFFFFFF, AnchorTimeMs: 900, SongID: 7},
+		{Address: 0, AnchorTimeMs: 0, SongID: 7},
+		{Address: 12345, AnchorTimeMs: 900, SongID: 7},
+		{Address: 1 << 31, AnchorTimeMs: math.MaxUint32, SongID: 7},
+	},
+	math.MaxUint32: {
+		{Address: 42, AnchorTimeMs: 5, SongID: math.MaxUint32},
+	},
+}
+
+func writeTestFile(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer, err := NewWriter(&buf, testHeader)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for _, song := range testSongs {
+		if err := writer.WriteSong(song); err != nil {
+			t.Fatalf("WriteSong: %v", err)
+		}
+	}
+	for _, song := range testSongs {
+		fingerprints := append([]db.Fingerprint(nil), testFingerprints[song.ID]...)
+		if err := writer.WriteFingerprints(song.ID, fingerprints); err != nil {
+			t.Fatalf("WriteFingerprints: %v", err)
+		}
+	}
+	// an empty run writes nothing
+	if err := writer.WriteFingerprints(99, nil); err != nil {
+		t.Fatalf("WriteFingerprints: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// everything in data, or the first error
+func readAll(data []byte) (Header, []db.Song, []db.Fingerprint, error) {
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return Header{}, nil, nil, err
+	}
+	songs, err := reader.Songs()
+	if err != nil {
+		return Header{}, nil, nil, err
+	}
+	var fingerprints []db.Fingerprint
+	for {
+		fp, err := reader.Next()
+		if err == io.EOF {
+			return reader.Header(), songs, fingerprints, nil
+		}
+		if err != nil {
+			return Header{}, nil, nil, err
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	header, songs, fingerprints, err := readAll(writeTestFile(t))
+	if err != nil {
+		t.Fatalf("reading back: %v", err)
+	}
+
+	if !header.Created.Equal(testHeader.Created) {
+		t.Errorf("created %v, want %v", header.Created, testHeader.Created)
+	}
+	header.Created = testHeader.Created
+	if header != testHeader {
+		t.Errorf("header %+v, want %+v", header, testHeader)
+	}
+
+	if len(songs) != len(testSongs) {
+		t.Fatalf("%d songs, want %d", len(songs), len(testSongs))
+	}
+	for i, song := range songs {
+		want := testSongs[i]
+		if !song.IngestedAt.Equal(want.IngestedAt) {
+			t.Errorf("song %d ingested at %v, want %v", want.ID, song.IngestedAt, want.IngestedAt)
+		}
+		song.IngestedAt = want.IngestedAt
+		if !reflect.DeepEqual(song, want) {
+			t.Errorf("song %+v, want %+v", song, want)
+		}
+	}
+
+	var want []db.Fingerprint
+	for _, song := range testSongs {
+		sorted := append([]db.Fingerprint(nil), testFingerprints[song.ID]...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].AnchorTimeMs < sorted[j].AnchorTimeMs })
+		want = append(want, sorted...)
+	}
+	if len(fingerprints) != len(want) {
+		t.Fatalf("%d fingerprints, want %d", len(fingerprints), len(want))
+	}
+	// equal anchor times can come back in either order
+	for i := range want {
+		if fingerprints[i].SongID != want[i].SongID || fingerprints[i].AnchorTimeMs != want[i].AnchorTimeMs {
+			t.Errorf("fingerprint %d is %+v, want %+v", i, fingerprints[i], want[i])
+		}
+	}
+	if got, expected := countAddresses(fingerprints), countAddresses(want); !reflect.DeepEqual(got, expected) {
+		t.Errorf("addresses %v, want %v", got, expected)
+	}
+}
+
+func countAddresses(fingerprints []db.Fingerprint) map[[2]uint32]int {
+	counts := make(map[[2]uint32]int)
+	for _, fp := range fingerprints {
+		counts[[2]uint32{fp.SongID, fp.Address}]++
+	}
+	return counts
+}
+
+func TestTruncated(t *testing.T) {
+	data := writeTestFile(t)
+	for n := 0; n < len(data); n++ {
+		if _, _, _, err := readAll(data[:n]); err == nil {
+			t.Errorf("file cut to %d of %d bytes read without an error", n, len(data))
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	data := writeTestFile(t)
+
+	// past the prefix and gzip's own header, whose mtime and OS bytes nothing checks
+	for i := len(MAGIC) + 2 + 10; i < len(data); i++ {
+		corrupt := bytes.Clone(data)
+		corrupt[i] ^= 0x55
+		if _, _, _, err := readAll(corrupt); err == nil {
+			t.Errorf("byte %d of %d flipped read without an error", i, len(data))
+		}
+	}
+
+	trailing := append(bytes.Clone(data), 0)
+	if _, _, _, err := readAll(trailing); err == nil {
+		t.Error("file with a trailing byte read without an error")
+	}
+}
+
+func TestNotIndexFile(t *testing.T) {
+	data := writeTestFile(t)
+
+	notIndex := bytes.Clone(data)
+	copy(notIndex, "NOTFINDR")
+	if _, err := NewReader(bytes.NewReader(notIndex)); !errors.Is(err, ErrNotIndexFile) {
+		t.Errorf("wrong magic gave %v, want ErrNotIndexFile", err)
+	}
+	if _, err := NewReader(bytes.NewReader(nil)); !errors.Is(err, ErrNotIndexFile) {
+		t.Errorf("empty file gave %v, want ErrNotIndexFile", err)
+	}
+
+	newer := bytes.Clone(data)
+	binary.BigEndian.PutUint16(newer[len(MAGIC):], FORMAT_VERSION+1)
+	if _, err := NewReader(bytes.NewReader(newer)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("newer version gave %v, want ErrUnsupportedVersion", err)
+	}
+}
@@ -0,0 +1,138 @@
+package indexfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+// the file's fingerprints were made differently, none of them would ever match a query
+var ErrIncompatible = errors.New("index file was fingerprinted with different parameters")
+
+// importing without merging needs an empty index
+var ErrIndexNotEmpty = errors.New("index already has songs")
+
+type ExportResult struct {
+	Songs        int
+	Fingerprints int64
+}
+
+type ImportOptions struct {
+	// add to an index that already has songs, songs already in it are skipped
+	Merge bool
+}
+
+// the header for an export from this build
+func currentHeader(songs int) (Header, error) {
+	chain, err := audio.IndexChain()
+	if err != nil {
+		return Header{}, err
+	}
+	return Header{
+		Version:    FORMAT_VERSION,
+		Created:    time.Now(),
+		SampleRate: audio.TARGET_SAMPLE_RATE,
+		Params:     fingerprintalgorithm.CurrentParameters(),
+		IndexChain: chain.String(),
+		Songs:      songs,
+	}, nil
+}
+
+/*
+writes every song and its fingerprints to w, as they were when the export started.
+Songs added or removed while it runs don't end up half in the file
+*/
+func Export(ctx context.Context, client db.DbClient, w io.Writer) (ExportResult, error) {
+	var writer *Writer
+	var result ExportResult
+
+	err := client.ExportIndex(ctx, func(songs []db.Song) error {
+		header, err := currentHeader(len(songs))
+		if err != nil {
+			return err
+		}
+		writer, err = NewWriter(w, header)
+		if err != nil {
+			return fmt.Errorf("error writing index file: %w", err)
+		}
+		for _, song := range songs {
+			if err := writer.WriteSong(song); err != nil {
+				return fmt.Errorf("error writing index file: %w", err)
+			}
+		}
+		result.Songs = len(songs)
+		return nil
+	}, func(songID uint32, fingerprints []db.Fingerprint) error {
+		result.Fingerprints += int64(len(fingerprints))
+		if err := writer.WriteFingerprints(songID, fingerprints); err != nil {
+			return fmt.Errorf("error writing index file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ExportResult{}, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return ExportResult{}, fmt.Errorf("error writing index file: %w", err)
+	}
+	return result, nil
+}
+
+// can this build match against what the file holds
+func checkCompatible(header Header) error {
+	current, err := currentHeader(0)
+	if err != nil {
+		return err
+	}
+	if header.SampleRate != current.SampleRate || header.Params != current.Params {
+		return fmt.Errorf("%w (file: %d Hz %+v, this build: %d Hz %+v)", ErrIncompatible, header.SampleRate, header.Params, current.SampleRate, current.Params)
+	}
+	if header.IndexChain != current.IndexChain {
+		log.Logger.Warnf("Index file songs were pre-processed with %q, this build indexes with %q, new songs won't be fingerprinted quite the same", header.IndexChain, current.IndexChain)
+	}
+	return nil
+}
+
+/*
+adds the songs and fingerprints in r to the index, under new songIDs, in one transaction.
+Nothing is imported if any of it fails
+*/
+func Import(ctx context.Context, client db.DbClient, r io.Reader, opts ImportOptions) (db.ImportResult, Header, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return db.ImportResult{}, Header{}, err
+	}
+	header := reader.Header()
+	if err := checkCompatible(header); err != nil {
+		return db.ImportResult{}, header, err
+	}
+
+	if !opts.Merge {
+		total, err := client.TotalSongs()
+		if err != nil {
+			return db.ImportResult{}, header, err
+		}
+		if total > 0 {
+			return db.ImportResult{}, header, fmt.Errorf("%w (%d), merge into it or import into an empty database", ErrIndexNotEmpty, total)
+		}
+	}
+
+	songs, err := reader.Songs()
+	if err != nil {
+		return db.ImportResult{}, header, err
+	}
+
+	result, err := client.ImportIndex(ctx, songs, reader.Next)
+	if err != nil {
+		return db.ImportResult{}, header, err
+	}
+	return result, header, nil
+}
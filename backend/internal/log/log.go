@@ -11,6 +11,9 @@ import (
 
 var Logger = logrus.New()
 
+// the log file Init opened, the console output can be moved without losing it
+var logFile *os.File
+
 func Init()(*os.File, error) {
 	// Logger.SetOutput(os.Stdout)
 
@@ -23,12 +26,14 @@ func Init()(*os.File, error) {
 	}
 
 	// Open log file
-	logFile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	file, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		Logger.WithError(err).Error("Failed to open log file")
 		return nil, err
 	}
 
+	logFile = file
+
 	// log to both stdout and the file
 	mw := io.MultiWriter(os.Stdout, logFile)
 	Logger.SetOutput(mw)
@@ -46,4 +51,13 @@ func Init()(*os.File, error) {
 	// set to true if you want the location of log (debugging)
 	Logger.SetReportCaller(false)
 	return logFile, nil
-}
\ No newline at end of file
+}
+
+// log to stderr (and the file) instead of stdout, for commands whose stdout is their output (-json, ...)
+func UseStderr() {
+	if logFile == nil {
+		Logger.SetOutput(os.Stderr)
+		return
+	}
+	Logger.SetOutput(io.MultiWriter(os.Stderr, logFile))
+}
@@ -0,0 +1,111 @@
+package match
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gen2brain/malgo"
+
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+type CaptureDevice struct {
+	ID        string
+	Name      string
+	IsDefault bool
+	info      malgo.DeviceInfo
+}
+
+// init a malgo context, the caller has to free it with freeAudioContext
+func initAudioContext() (*malgo.AllocatedContext, error) {
+	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, func(message string) {
+		log.Logger.Debugf("malgo: %s", message)
+	})
+	if err != nil {
+		log.Logger.WithError(err).Error("Failed to initialize audio context")
+		return nil, err
+	}
+	return ctx, nil
+}
+
+func freeAudioContext(ctx *malgo.AllocatedContext) {
+	_ = ctx.Uninit()
+	ctx.Free()
+}
+
+func captureDevices(ctx *malgo.AllocatedContext) ([]CaptureDevice, error) {
+	infos, err := ctx.Devices(malgo.Capture)
+	if err != nil {
+		log.Logger.WithError(err).Error("Failed to enumerate capture devices")
+		return nil, err
+	}
+
+	devices := make([]CaptureDevice, 0, len(infos))
+	for _, info := range infos {
+		devices = append(devices, CaptureDevice{
+			ID:        info.ID.String(),
+			Name:      info.Name(),
+			IsDefault: info.IsDefault != 0,
+			info:      info,
+		})
+	}
+	return devices, nil
+}
+
+// every capture device the backend can see (mics, line ins, loopback/monitor sources)
+func ListCaptureDevices() ([]CaptureDevice, error) {
+	ctx, err := initAudioContext()
+	if err != nil {
+		return nil, err
+	}
+	defer freeAudioContext(ctx)
+
+	return captureDevices(ctx)
+}
+
+/*
+pick a device by its exact ID, or by name (exact match wins over a substring match).
+returns nil if neither is set, which means the system's default device.
+*/
+func selectCaptureDevice(ctx *malgo.AllocatedContext, id, name string) (*CaptureDevice, error) {
+	if id == "" && name == "" {
+		return nil, nil
+	}
+
+	devices, err := captureDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if id != "" {
+		for i := range devices {
+			if strings.EqualFold(devices[i].ID, id) {
+				return &devices[i], nil
+			}
+		}
+		return nil, fmt.Errorf("no capture device with ID %q (see 'devices list')", id)
+	}
+
+	var partial []*CaptureDevice
+	for i := range devices {
+		if strings.EqualFold(devices[i].Name, name) {
+			return &devices[i], nil
+		}
+		if strings.Contains(strings.ToLower(devices[i].Name), strings.ToLower(name)) {
+			partial = append(partial, &devices[i])
+		}
+	}
+
+	switch len(partial) {
+	case 0:
+		return nil, fmt.Errorf("no capture device named %q (see 'devices list')", name)
+	case 1:
+		return partial[0], nil
+	default:
+		names := make([]string, len(partial))
+		for i, d := range partial {
+			names[i] = d.Name
+		}
+		return nil, fmt.Errorf("device name %q is ambiguous, matches: %s", name, strings.Join(names, ", "))
+	}
+}
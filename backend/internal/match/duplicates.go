@@ -0,0 +1,128 @@
+package match
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+)
+
+/*
+the same recording fingerprinted twice shares most of its hashes at one fixed offset, two
+different songs only share the odd random one. A song that's a cut of the other (radio edit,
+intro trimmed off) counts as well, similarity is taken over the shorter of the two.
+Re-encodes of the same recording line up well over half their hashes, so anything below that
+is left alone rather than risk skipping a different song on the way in
+*/
+const (
+	DUPLICATE_MIN_SIMILARITY = 0.5
+	duplicateMinAligned      = 50 // whatever the similarity, fewer aligned hashes than this is chance
+)
+
+// an indexed song that a whole song's fingerprints are (most likely) a copy of
+type Duplicate struct {
+	Song       db.Song
+	Similarity float64 // share of the shorter song's hashes that line up, 0 to 1
+	Aligned    int     // hashes at the best offset
+	OffsetMs   int64   // where the song starts in Song
+}
+
+/*
+indexed songs that fingerprints (a whole song, hash -> anchor time) is a copy of, most similar first.
+exclude is left out, 0 for a song that isn't indexed yet.
+
+scored from a histogram of dbTime - anchorTime instead of Match's pairwise count, which is
+O(N^2) in the couples and a whole song has tens of thousands. Stop-listed hashes are skipped,
+whatever the stop-list mode, on both sides of the similarity
+*/
+func (m *Matcher) FindDuplicates(ctx context.Context, fingerprints map[uint32]uint32, exclude uint32, minSimilarity float64) ([]Duplicate, error) {
+	hashes := make([]uint32, 0, len(fingerprints))
+	for hash := range fingerprints {
+		hashes = append(hashes, hash)
+	}
+
+	stopListed, err := m.stopListed(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	if len(stopListed) > 0 {
+		kept := hashes[:0]
+		for _, hash := range hashes {
+			if _, ok := stopListed[hash]; !ok {
+				kept = append(kept, hash)
+			}
+		}
+		hashes = kept
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	couples, err := m.db.GetCouplesContext(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[uint32]map[int64]int) // songID -> offset bin -> hashes
+	for hash, found := range couples {
+		anchorTime := int64(fingerprints[hash])
+		for _, couple := range found {
+			if couple.SongID == exclude {
+				continue
+			}
+			if offsets[couple.SongID] == nil {
+				offsets[couple.SongID] = make(map[int64]int)
+			}
+			bin := int64(math.Round(float64(int64(couple.AnchorTimeMs)-anchorTime) / TOLERANCE))
+			offsets[couple.SongID][bin]++
+		}
+	}
+
+	var duplicates []Duplicate
+	for songID, bins := range offsets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// the best bin with its neighbours, an offset right on a bin edge splits between them
+		var aligned int
+		var offset int64
+		for bin := range bins {
+			if count := bins[bin-1] + bins[bin] + bins[bin+1]; count > aligned || (count == aligned && bin < offset) {
+				aligned, offset = count, bin
+			}
+		}
+		if aligned < duplicateMinAligned {
+			continue
+		}
+
+		// distinct hashes and no stop-listed ones, like the query side
+		indexed, err := m.db.CountSongAddresses(ctx, songID, m.opts.MaxAddressSongs)
+		if err != nil {
+			return nil, err
+		}
+		shorter := min(len(hashes), indexed)
+		if shorter == 0 {
+			continue
+		}
+		similarity := min(1, float64(aligned)/float64(shorter))
+		if similarity < minSimilarity {
+			continue
+		}
+
+		song, found, err := m.db.GetSongByIDContext(ctx, songID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue // an orphan, 'db gc' removes those
+		}
+		duplicates = append(duplicates, Duplicate{Song: song, Similarity: similarity, Aligned: aligned, OffsetMs: offset * TOLERANCE})
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Similarity > duplicates[j].Similarity
+	})
+	return duplicates, nil
+}
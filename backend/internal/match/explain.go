@@ -0,0 +1,198 @@
+package match
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+)
+
+const topOffsetCount = 5
+
+// why the matcher ranked the candidates the way it did
+type Explanation struct {
+	Recording       string                 `json:"recording,omitempty"`
+	Diagnostics     *audio.Diagnostics     `json:"diagnostics,omitempty"` // how good the recording itself was
+	QueryHashes     int                    `json:"query_hashes"`          // hashes generated from the query
+	HashesInDb      int                    `json:"hashes_in_db"`          // query hashes with at least one couple in the db
+	CouplesReturned int                    `json:"couples_returned"`      // couples fetched over all songs
+	StopListed      int                    `json:"stop_listed"`           // query hashes in too many songs, skipped or down-weighted
+	Candidates      []CandidateExplanation `json:"candidates"`
+}
+
+type CandidateExplanation struct {
+	Rank          int     `json:"rank"`
+	SongID        uint32  `json:"song_id"`
+	Title         string  `json:"title"`
+	Artist        string  `json:"artist"`
+	Score         float64 `json:"score"`
+	SpeedFactor   float64 `json:"speed_factor"`
+	PitchFactor   float64 `json:"pitch_factor"`
+	HashesMatched int     `json:"hashes_matched"` // distinct query hashes that hit this song
+	Couples       int     `json:"couples"`        // (sampleTime, dbTime) couples for this song
+	Aligned       int     `json:"aligned"`        // couples in the best offset bin (or its neighbours)
+	// dbTime - sampleTime in TOLERANCE wide bins, a true match piles up in one bin
+	OffsetHistogram []OffsetBin `json:"offset_histogram"`
+	TopOffsets      []OffsetBin `json:"top_offsets"`
+	Hashes          []HashHit   `json:"hashes"`
+}
+
+type OffsetBin struct {
+	OffsetMs int64 `json:"offset_ms"`
+	Count    int   `json:"count"`
+}
+
+type HashHit struct {
+	Hash         uint32 `json:"hash"`
+	SampleTimeMs uint32 `json:"sample_time_ms"`
+	DbTimeMs     uint32 `json:"db_time_ms"`
+	OffsetMs     int64  `json:"offset_ms"`
+	Aligned      bool   `json:"aligned"`
+}
+
+func explain(variants []queryVariant, found lookup, matches []Match, top int) *Explanation {
+	couples := found.couples
+	explanation := &Explanation{
+		QueryHashes: len(variants[0].hashes),
+		HashesInDb:  len(couples),
+		StopListed:  len(found.stopListed),
+	}
+	for _, c := range couples {
+		explanation.CouplesReturned += len(c)
+	}
+
+	if top > len(matches) {
+		top = len(matches)
+	}
+	for rank, m := range matches[:top] {
+		explanation.Candidates = append(explanation.Candidates, explainCandidate(rank+1, m, variants, couples))
+	}
+
+	return explanation
+}
+
+func explainCandidate(rank int, m Match, variants []queryVariant, couples map[uint32][]fingerprintalgorithm.Couple) CandidateExplanation {
+	candidate := CandidateExplanation{
+		Rank:        rank,
+		SongID:      m.SongID,
+		Title:       m.SongTitle,
+		Artist:      m.SongArtist,
+		Score:       m.Score,
+		SpeedFactor: m.SpeedFactor,
+		PitchFactor: m.PitchFactor,
+	}
+
+	// the query variant the score came from (only not the recording itself with tempo tolerant matching)
+	variant := variants[0]
+	for _, v := range variants {
+		if math.Abs(v.pitch-m.PitchFactor) < 1e-9 {
+			variant = v
+		}
+	}
+	stretch := 1.0
+	if m.PitchFactor > 0 {
+		stretch = m.SpeedFactor / m.PitchFactor
+	}
+
+	histogram := map[int64]int{}
+	for hash, sampleTime := range variant.hashes {
+		matched := false
+		for _, couple := range couples[hash] {
+			if couple.SongID != m.SongID {
+				continue
+			}
+			matched = true
+			offset := int64(math.Round(float64(couple.AnchorTimeMs) - stretch*float64(sampleTime)))
+			candidate.Hashes = append(candidate.Hashes, HashHit{
+				Hash:         hash,
+				SampleTimeMs: sampleTime,
+				DbTimeMs:     couple.AnchorTimeMs,
+				OffsetMs:     offset,
+			})
+			histogram[offsetBin(offset)]++
+		}
+		if matched {
+			candidate.HashesMatched++
+		}
+	}
+	candidate.Couples = len(candidate.Hashes)
+
+	for bin, count := range histogram {
+		candidate.OffsetHistogram = append(candidate.OffsetHistogram, OffsetBin{OffsetMs: bin * TOLERANCE, Count: count})
+	}
+	sort.Slice(candidate.OffsetHistogram, func(i, j int) bool {
+		return candidate.OffsetHistogram[i].OffsetMs < candidate.OffsetHistogram[j].OffsetMs
+	})
+
+	candidate.TopOffsets = append([]OffsetBin(nil), candidate.OffsetHistogram...)
+	sort.SliceStable(candidate.TopOffsets, func(i, j int) bool {
+		return candidate.TopOffsets[i].Count > candidate.TopOffsets[j].Count
+	})
+	if len(candidate.TopOffsets) > topOffsetCount {
+		candidate.TopOffsets = candidate.TopOffsets[:topOffsetCount]
+	}
+
+	// a couple is aligned if it's in (or right next to) the most common offset bin
+	if len(candidate.TopOffsets) > 0 {
+		best := candidate.TopOffsets[0].OffsetMs / TOLERANCE
+		for i := range candidate.Hashes {
+			diff := offsetBin(candidate.Hashes[i].OffsetMs) - best
+			if diff >= -1 && diff <= 1 {
+				candidate.Hashes[i].Aligned = true
+				candidate.Aligned++
+			}
+		}
+	}
+
+	sort.Slice(candidate.Hashes, func(i, j int) bool {
+		return candidate.Hashes[i].SampleTimeMs < candidate.Hashes[j].SampleTimeMs
+	})
+
+	return candidate
+}
+
+func offsetBin(offset int64) int64 {
+	return int64(math.Floor(float64(offset) / TOLERANCE))
+}
+
+// -explain-out that writes the report to stderr
+const EXPLAIN_STDERR = "-"
+
+// EXPLAIN_STDERR as the path keeps the report apart from the matches (or -json) on stdout
+func WriteExplanation(path string, explanation *Explanation) error {
+	data, err := json.MarshalIndent(explanation, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path == EXPLAIN_STDERR {
+		_, err = os.Stderr.Write(append(data, '\n'))
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// the short version of the JSON for the terminal
+func printExplanation(explanation *Explanation, path string) {
+	fmt.Printf("\nExplain -> query hashes: %d | found in db: %d | couples returned: %d\n",
+		explanation.QueryHashes, explanation.HashesInDb, explanation.CouplesReturned)
+	for _, c := range explanation.Candidates {
+		fmt.Printf("\t%d. %s by %s, score: %.2f\n", c.Rank, c.Title, c.Artist, c.Score)
+		fmt.Printf("\t   hashes matched: %d | couples: %d | aligned: %d\n", c.HashesMatched, c.Couples, c.Aligned)
+		if len(c.TopOffsets) > 0 {
+			fmt.Printf("\t   top offsets:")
+			for _, o := range c.TopOffsets {
+				fmt.Printf(" %+dms (%d)", o.OffsetMs, o.Count)
+			}
+			fmt.Println()
+		}
+	}
+	if path == EXPLAIN_STDERR {
+		fmt.Println("Full explanation written to stderr")
+	} else if path != "" {
+		fmt.Printf("Full explanation written to %s\n", path)
+	}
+}
@@ -1,11 +1,12 @@
 package match
 
 import (
+	"context"
 	"math"
-	"math/rand"
 	"sort"
 	"time"
 
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
 	"github.com/ONESHO1/FINDR/backend/internal/db"
 	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
 	"github.com/ONESHO1/FINDR/backend/internal/log"
@@ -14,66 +15,136 @@ import (
 const TOLERANCE = 100 		// tolerance in time difference
 
 type Match struct {
-	SongID     uint32
-	SongTitle  string
-	SongArtist string
-	// YouTubeID  string
-	Timestamp  uint32
-	Score      float64
+	SongID     uint32 `json:"song_id"`
+	SongTitle  string `json:"title"`
+	SongArtist string `json:"artist"`
+	// the rest of what's known about the song, empty for songs added before it was recorded
+	SongArtists  []string  `json:"artists,omitempty"`
+	SongAlbum    string    `json:"album,omitempty"`
+	SongDuration float64   `json:"duration,omitempty"` // seconds
+	SpotifyID    string    `json:"spotify_id,omitempty"`
+	YouTubeID    string    `json:"youtube_id,omitempty"`
+	SourceURL    string    `json:"source_url,omitempty"`
+	IngestedAt   time.Time `json:"ingested_at,omitzero"`
+	Timestamp    uint32    `json:"timestamp"`
+	Score        float64   `json:"score"`
+	// query speed/pitch relative to the indexed song, always 1 unless tempo tolerant matching is on
+	SpeedFactor float64 `json:"speed_factor"`
+	PitchFactor float64 `json:"pitch_factor"`
 }
 
-func FindMatches(sample []float64, duration float64, sampleRate int) ([]Match, time.Duration, error) {
-	start := time.Now()
+// how a query gets matched against the db
+type Options struct {
+	// look for sped-up/pitched versions and DJ tempo changes too
+	TempoTolerant bool
+	// biggest speed/pitch change looked for (0.12 = ±12%) and how finely to step through it,
+	// high frequency bins only line up again within ~0.5% of the real factor
+	MaxShift  float64
+	ShiftStep float64
+	// give up on a query after this long, 0 waits as long as it takes
+	Timeout time.Duration
+	// run on the query before the spectrogram, separate from what songs were indexed with
+	Preprocess audio.Chain
+	// hashes in more than this many songs are stop-listed, 0 turns the stop-list off
+	MaxAddressSongs int
+	// STOPLIST_SKIP leaves them out of the lookup, STOPLIST_WEIGHT scores them at MaxAddressSongs/songs
+	StopList string
+}
 
-	spectrogram, err := fingerprintalgorithm.Spectrogram(sample, sampleRate)
+func DefaultOptions() Options {
+	maxSongs, stopList := stopListFromEnv()
+	// only fails if the built in default doesn't parse, queries then go in as they are
+	preprocess, err := audio.QueryChain()
 	if err != nil {
-		log.Logger.WithError(err).Error("error finding samples")
-		return nil, time.Since(start), err
-	} 
+		log.Logger.WithError(err).Error("Could not get the query pre-processing chain")
+	}
+	return Options{
+		MaxShift:        0.12,
+		ShiftStep:       0.004,
+		Preprocess:      preprocess,
+		MaxAddressSongs: maxSongs,
+		StopList:        stopList,
+	}
+}
 
-	peaks := fingerprintalgorithm.GetPeaksFromSpectrogram(spectrogram, sampleRate)
-	sampleFingerprint := fingerprintalgorithm.Fingerprint(peaks, rand.Uint32())
+func FindMatches(sample []float64, duration float64, sampleRate int) ([]Match, time.Duration, error) {
+	return FindMatchesWithOptions(sample, duration, sampleRate, DefaultOptions())
+}
 
-	sampleFingerprintMap := make(map[uint32]uint32)
-	for hash, couple := range sampleFingerprint {
-		sampleFingerprintMap[hash] = couple.AnchorTimeMs
-	}
+// one off match with its own db connection, anything matching more than once should hold on to a Matcher
+func FindMatchesWithOptions(sample []float64, duration float64, sampleRate int, opts Options) ([]Match, time.Duration, error) {
+	start := time.Now()
 
-	matches, err := findMatchesFromDb(sampleFingerprintMap)
+	client, err := db.NewDbClient()
 	if err != nil {
-		log.Logger.WithError(err).Error("error finding matches")
+		log.Logger.WithError(err).Error("error connecting to db")
 		return nil, time.Since(start), err
 	}
+	defer client.Close()
 
-	return matches, time.Since(start), nil
+	matches, err := NewMatcher(client, opts).Match(context.Background(), sample, sampleRate)
+	return matches, time.Since(start), err
 }
 
-func findMatchesFromDb(sampleFingerprintMap map[uint32]uint32) ([]Match, error) {
-	tmp := make([]uint32, 0, len(sampleFingerprintMap))
-	for hash := range sampleFingerprintMap {
-		tmp = append(tmp, hash)
+// what the db had for a query, for explaining the result
+type lookup struct {
+	couples    map[uint32][]fingerprintalgorithm.Couple // hash -> couples, without skipped hashes
+	stopListed map[uint32]int                           // hash -> songs, for the stop-listed query hashes
+}
+
+func (m *Matcher) findMatchesFromDb(ctx context.Context, variants []queryVariant) ([]Match, lookup, error) {
+	opts := m.opts
+
+	tmp := make([]uint32, 0, len(variants[0].hashes))
+	seen := make(map[uint32]bool)
+	for _, variant := range variants {
+		for hash := range variant.hashes {
+			if !seen[hash] {
+				seen[hash] = true
+				tmp = append(tmp, hash)
+			}
+		}
 	}
 
-	db, err := db.NewDbClient()
+	stopListed, err := m.stopListed(ctx, tmp)
 	if err != nil {
-		log.Logger.WithError(err).Error("error connecting to db")
-		return nil, err
+		log.Logger.WithError(err).Error("couldnt get the stop-listed hashes from db")
+		return nil, lookup{}, err
+	}
+	if opts.StopList == STOPLIST_SKIP && len(stopListed) > 0 {
+		kept := tmp[:0]
+		for _, hash := range tmp {
+			if _, ok := stopListed[hash]; !ok {
+				kept = append(kept, hash)
+			}
+		}
+		tmp = kept
 	}
-	defer db.Close()
 
-	n, err := db.GetCouples(tmp)
+	n, err := m.db.GetCouplesContext(ctx, tmp)
 	if err != nil {
 		log.Logger.WithError(err).Error("couldnt get couples from db")
-		return nil, err
+		return nil, lookup{}, err
 	}
 
-	matches := map[uint32][][2]uint32{}        // songID -> [(sampleTime, dbTime)]
+	matches := map[uint32][][][2]uint32{}      // songID -> variant -> [(sampleTime, dbTime)]
+	weights := map[uint32][][]float64{}        // songID -> variant -> weight of each of those
 	timestamps := map[uint32]uint32{}          // songID -> earliest timestamp
 	targetZones := map[uint32]map[uint32]int{} // songID -> timestamp -> count
 
 	for hash, couples := range n {
+		weight := m.hashWeight(stopListed, hash)
 		for _, couple := range couples {
-			matches[couple.SongID] = append(matches[couple.SongID], [2]uint32{sampleFingerprintMap[hash], couple.AnchorTimeMs})
+			if _, ok := matches[couple.SongID]; !ok {
+				matches[couple.SongID] = make([][][2]uint32, len(variants))
+				weights[couple.SongID] = make([][]float64, len(variants))
+			}
+			for v, variant := range variants {
+				if sampleTime, ok := variant.hashes[hash]; ok {
+					matches[couple.SongID][v] = append(matches[couple.SongID][v], [2]uint32{sampleTime, couple.AnchorTimeMs})
+					weights[couple.SongID][v] = append(weights[couple.SongID][v], weight)
+				}
+			}
 
 			if existingTime, ok := timestamps[couple.SongID]; !ok || couple.AnchorTimeMs < existingTime {
 				timestamps[couple.SongID] = couple.AnchorTimeMs
@@ -88,29 +159,64 @@ func findMatchesFromDb(sampleFingerprintMap map[uint32]uint32) ([]Match, error)
 	}
 
 	scores := make(map[uint32]float64)
+	speeds := make(map[uint32]float64)
+	pitches := make(map[uint32]float64)
 
 	/* 
 	get the score for each songID from the differences in the recording time and db(saved) time
 	I can't get myself to write O(N^3) after doing so many lc qns xD
 	*/
-	for songID, times := range matches {
-		count := 0
-		for i := 0 ; i < len(times) ; i++ {
-			for j := i + 1 ; j < len(times) ; j++ {
-				sampleTimeDiff := math.Abs(float64(times[i][0] - times[j][0]))
-				dbTimeDiff := math.Abs(float64(times[i][1] - times[j][1]))
-				if math.Abs(sampleTimeDiff - dbTimeDiff) <= TOLERANCE {
-					count++
+	for songID, byVariant := range matches {
+		if err := ctx.Err(); err != nil {
+			return nil, lookup{}, err
+		}
+
+		speeds[songID], pitches[songID] = 1, 1
+		for v, times := range byVariant {
+			if len(times) == 0 {
+				continue
+			}
+
+			// an aligned pair counts for the product of its hashes' weights, 1 without the weighted stop-list
+			w := weights[songID][v]
+			var count float64
+			stretch := 1.0
+			if opts.TempoTolerant {
+				var inliers []int
+				stretch, inliers = estimateStretch(times, opts.MaxShift)
+				count = stretchedScore(inliers, w)
+			} else {
+				for i := 0 ; i < len(times) ; i++ {
+					for j := i + 1 ; j < len(times) ; j++ {
+						sampleTimeDiff := math.Abs(float64(times[i][0] - times[j][0]))
+						dbTimeDiff := math.Abs(float64(times[i][1] - times[j][1]))
+						if math.Abs(sampleTimeDiff - dbTimeDiff) <= TOLERANCE {
+							count += w[i] * w[j]
+						}
+					}
 				}
 			}
+
+			if count > scores[songID] {
+				scores[songID] = count
+				// the variant's times were already scaled by its pitch factor
+				speeds[songID] = variants[v].pitch * stretch
+				pitches[songID] = variants[v].pitch
+			}
+		}
+		if _, ok := scores[songID]; !ok {
+			scores[songID] = 0
 		}
-		scores[songID] = float64(count)
 	}
 
 	var finalMatches []Match
 
 	for songID, score := range scores {
-		song, songExists, err := db.GetSongByID(songID)
+		if err := ctx.Err(); err != nil {
+			return nil, lookup{}, err
+		}
+
+		song, songExists, err := m.db.GetSongByIDContext(ctx, songID)
 		if !songExists {
 			log.Logger.Errorf("songID: %d doesnt exist", songID)
 			continue
@@ -123,8 +229,17 @@ func findMatchesFromDb(sampleFingerprintMap map[uint32]uint32) ([]Match, error)
 			SongID: songID,
 			SongTitle: song.Title,
 			SongArtist: song.Artist,
+			SongArtists: song.Artists,
+			SongAlbum: song.Album,
+			SongDuration: song.Duration.Seconds(),
+			SpotifyID: song.SpotifyID,
+			YouTubeID: song.YouTubeID,
+			SourceURL: song.SourceURL,
+			IngestedAt: song.IngestedAt,
 			Timestamp: timestamps[songID],
 			Score: score,
+			SpeedFactor: speeds[songID],
+			PitchFactor: pitches[songID],
 		}
 		finalMatches = append(finalMatches, match)
 	}
@@ -133,5 +248,16 @@ func findMatchesFromDb(sampleFingerprintMap map[uint32]uint32) ([]Match, error)
 		return finalMatches[i].Score > finalMatches[j].Score
 	})
 
-	return finalMatches, nil
-}
\ No newline at end of file
+	return finalMatches, lookup{couples: n, stopListed: stopListed}, nil
+}
+
+// how far ahead of the runner up the best match is, 0 (a tie) to 1 (nothing else matched)
+func Confidence(matches []Match) float64 {
+	if len(matches) == 0 || matches[0].Score <= 0 {
+		return 0
+	}
+	if len(matches) == 1 {
+		return 1
+	}
+	return (matches[0].Score - matches[1].Score) / matches[0].Score
+}
@@ -1,31 +1,77 @@
 package match
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	"github.com/ONESHO1/FINDR/backend/internal/db"
 	"github.com/ONESHO1/FINDR/backend/internal/log"
 	"github.com/ONESHO1/FINDR/backend/internal/wav"
 	"github.com/gen2brain/malgo"
+	"github.com/sirupsen/logrus"
 )
 
 const RECORDINGS_DIR = "recordings"
 const RECORDING_TIME = 25 // in seconds
 
-func RecordAndFind() {
-	path, err := recordFromMic()
+// what to record from, and how
+type RecordOptions struct {
+	DeviceID   string // exact ID from 'devices list'
+	DeviceName string // exact or partial device name
+	SampleRate uint32
+	Channels   uint32
+	Duration   time.Duration
+	Matching   Options
+	Explain    int    // > 0 writes an explanation of that many top candidates
+	ExplainOut string // where the explanation goes, next to the recording when empty
+	JSON       bool   // print a Result as JSON instead of the human readable output
+}
+
+func DefaultRecordOptions() RecordOptions {
+	return RecordOptions{
+		SampleRate: 44100,
+		Channels:   1,
+		Duration:   RECORDING_TIME * time.Second,
+		Matching:   DefaultOptions(),
+	}
+}
+
+func (o RecordOptions) validate() error {
+	if o.Channels == 0 {
+		return fmt.Errorf("channel count must be above 0")
+	}
+	if o.SampleRate == 0 {
+		return fmt.Errorf("unsupported sample rate: %d", o.SampleRate)
+	}
+	if o.Duration <= 0 {
+		return fmt.Errorf("recording duration must be above 0, got %s", o.Duration)
+	}
+	return nil
+}
+
+func RecordAndFind(opts RecordOptions) {
+	if err := opts.validate(); err != nil {
+		log.Logger.WithError(err).Error("Invalid recording options")
+		return
+	}
+
+	path, err := recordFromMic(opts)
 	if err != nil {
 		log.Logger.WithError(err).Error("Failed to record audio")
 		return
 	}
 
-	err = find(path)
+	err = find(path, opts)
 	if err != nil {
 		log.Logger.WithError(err).Error("Could not Find match")
 		return
@@ -39,25 +85,58 @@ i dont know if it will be clear enough for the fingerprinting to be accurate, le
 
 I'm a dumbass, record with the audio source pointing at the microphone
 */
-func recordFromMic() (string, error) {
+func recordFromMic(opts RecordOptions) (string, error) {
 	// use windows' audio thing
-	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, func(message string) {
-		log.Logger.Debugf("malgo: %s", message)
-	})
+	ctx, err := initAudioContext()
 	if err != nil {
-		log.Logger.WithError(err).Fatal("Failed to initialize audio context")
 		return "", err
 	}
-	defer func() {
-		_ = ctx.Uninit()
-		ctx.Free()
-	}()
+	defer freeAudioContext(ctx)
+
+	device, err := selectCaptureDevice(ctx, opts.DeviceID, opts.DeviceName)
+	if err != nil {
+		log.Logger.WithError(err).Error("Failed to select capture device")
+		return "", err
+	}
 
-	// just testing
 	deviceConfig := malgo.DefaultDeviceConfig(malgo.Capture)
 	deviceConfig.Capture.Format = malgo.FormatS16
-	deviceConfig.Capture.Channels = 1
-	deviceConfig.SampleRate = 44100
+	deviceConfig.Capture.Channels = opts.Channels
+	deviceConfig.SampleRate = opts.SampleRate
+	if device != nil {
+		deviceConfig.Capture.DeviceID = device.info.ID.Pointer()
+		log.Logger.WithFields(logrus.Fields{
+			"device_id":   device.ID,
+			"device_name": device.Name,
+		}).Info("Using capture device")
+	}
+
+	if err := os.MkdirAll(RECORDINGS_DIR, os.ModePerm); err != nil {
+		log.Logger.WithError(err).Error("Failed to create recordings directory")
+		return "", fmt.Errorf("failed to create recordings dir: %w", err)
+	}
+
+	filename := fmt.Sprintf("rec_%d_%d.wav", time.Now().Unix(), rand.Intn(10000))
+	outputPath := filepath.Join(RECORDINGS_DIR, filename)
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		log.Logger.WithError(err).WithField("outputPath", outputPath).Error("Failed to create output WAV file")
+		return "", err
+	}
+	defer outputFile.Close()
+
+	// the audio goes straight to disk instead of piling up in memory
+	encoder, err := wav.NewEncoder(outputFile, wav.Format{
+		AudioFormat:   wav.FORMAT_PCM,
+		Channels:      int(deviceConfig.Capture.Channels),
+		SampleRate:    int(deviceConfig.SampleRate),
+		BitsPerSample: 16,
+	})
+	if err != nil {
+		log.Logger.WithError(err).Error("Failed to write WAV header")
+		return "", err
+	}
 
 	// Use a channel to safely pass data from the audio thread to the main thread.
 	dataChan := make(chan []byte, 100) // Buffered channel (max 100 chunks)
@@ -80,42 +159,45 @@ func recordFromMic() (string, error) {
 		Data: onRecvFrames,
 	}
 
-	// system's default mic
-	device, err := malgo.InitDevice(ctx.Context, deviceConfig, deviceCallbacks)
+	// system's default mic unless a device was picked
+	captureDevice, err := malgo.InitDevice(ctx.Context, deviceConfig, deviceCallbacks)
 	if err != nil {
-		log.Logger.WithError(err).Fatal("Failed to initialize audio device")
+		log.Logger.WithError(err).Error("Failed to initialize audio device")
 		return "", err
 	}
-	defer device.Uninit()
+	defer captureDevice.Uninit()
 
 	/*
 		Use a WaitGroup and a separate goroutine to collect data from the channel.
-		This ensures all 'append' operations happen in one safe place.
+		This ensures all writes happen in one safe place.
 	*/
-	var capturedData bytes.Buffer
+	var writeErr error
 	var wg sync.WaitGroup
 	wg.Add(1)
 	// use seperate collectors
 	go func() {
 		defer wg.Done()
 		for data := range dataChan {
-			capturedData.Write(data)
+			// keep draining so the audio thread never blocks, but stop writing after a failure
+			if writeErr == nil {
+				_, writeErr = encoder.Write(data)
+			}
 		}
 	}()
 
-	log.Logger.Infof("Recording for %d seconds...", RECORDING_TIME)
-	err = device.Start()
+	log.Logger.Infof("Recording for %s...", opts.Duration)
+	err = captureDevice.Start()
 	if err != nil {
-		log.Logger.WithError(err).Fatal("Failed to start audio device")
+		log.Logger.WithError(err).Error("Failed to start audio device")
 		// close channel for failures
 		close(dataChan)
 		wg.Wait()
 		return "", err
 	}
 
-	time.Sleep(RECORDING_TIME * time.Second)
+	time.Sleep(opts.Duration)
 
-	_ = device.Stop()
+	_ = captureDevice.Stop()
 	log.Logger.Info("Recording stopped, saving to file...")
 
 	// Close channel
@@ -123,86 +205,225 @@ func recordFromMic() (string, error) {
 	// Wait for collection goroutines
 	wg.Wait()
 
-	if err := os.MkdirAll(RECORDINGS_DIR, os.ModePerm); err != nil {
-		log.Logger.WithError(err).Error("Failed to create recordings directory")
-		return "", fmt.Errorf("failed to create recordings dir: %w", err)
+	if writeErr != nil {
+		log.Logger.WithError(writeErr).Error("Failed to write WAV data")
+		return "", writeErr
 	}
 
-	filename := fmt.Sprintf("rec_%d_%d.wav", time.Now().Unix(), rand.Intn(10000))
-	outputPath := filepath.Join(RECORDINGS_DIR, filename)
-
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		log.Logger.WithError(err).WithField("outputPath", outputPath).Error("Failed to create output WAV file")
-		return "", err
-	}
-	defer outputFile.Close()
-
-	finalAudioData := capturedData.Bytes()
-	dataSize := uint32(len(finalAudioData))
-	sampleRate := uint16(deviceConfig.SampleRate)
-	bitsPerSample := uint16(16)
-	channels := uint16(deviceConfig.Capture.Channels)
-
 	// hah, gottem
-	if err := wav.WriteWavHeader(outputFile, dataSize, sampleRate, bitsPerSample, channels); err != nil {
+	if err := encoder.Close(); err != nil {
 		log.Logger.WithError(err).Error("Failed to write WAV header")
 		return "", err
 	}
 
-	if _, err := outputFile.Write(finalAudioData); err != nil {
-		log.Logger.WithError(err).Error("Failed to write WAV data")
-		return "", err
-	}
-
 	log.Logger.WithField("path", outputPath).Info("Recording saved successfully")
 	return outputPath, nil
 }
 
-func find(filePath string) error {
-	// just doing this incase I want to accept audio files in the future (since I'm already recording in single channel)
-	// monoFilePath, err := wav.ConvertToWav(filePath, 1)
-	// if err != nil {
-	// 	log.Logger.WithError(err).Error("Failed to convert query audio to mono")
-	// 	return err
-	// }
-	// defer os.Remove(monoFilePath)
+func find(filePath string, opts RecordOptions) error {
+	// the raw capture, so clipping on one channel isn't averaged away by the downmix
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Logger.WithError(err).Error("error opening recording")
+		return err
+	}
+	decoder, err := wav.NewDecoder(file)
+	if err != nil {
+		file.Close()
+		log.Logger.WithError(err).Error("error reading recording")
+		return err
+	}
+	raw, err := decoder.ReadAll()
+	file.Close()
+	if err != nil {
+		log.Logger.WithError(err).Error("error reading recording")
+		return err
+	}
+	format := decoder.Format()
+
+	diagnostics := audio.Analyze(raw, format.Channels, format.SampleRate)
+	warnDiagnostics(diagnostics)
 
-	wavInfo, err := wav.WavInfo(filePath)
+	// recordings from other devices can be stereo or at a different rate, the fingerprints need mono 44.1kHz
+	samples, err := audio.ToMono(raw, format.Channels, format.SampleRate)
 	if err != nil {
-		log.Logger.WithError(err).Error("error reafing wav file info")
+		log.Logger.WithError(err).Error("error converting recording")
 		return err
 	}
 
-	samples, err := wav.Samples(wavInfo.Data)
+	explainPath := ""
+	if opts.Explain > 0 {
+		explainPath = opts.ExplainOut
+		if explainPath == "" {
+			explainPath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".explain.json"
+		}
+	}
+
+	client, err := db.NewDbClient()
 	if err != nil {
-		log.Logger.WithError(err).Error("error generating samples")
+		log.Logger.WithError(err).Error("error connecting to db")
 		return err
 	}
+	defer client.Close()
+
+	return recognise(context.Background(), NewMatcher(client, opts.Matching), samples, recognition{
+		recording:   filePath,
+		explain:     opts.Explain,
+		explainPath: explainPath,
+		json:        opts.JSON,
+		diagnostics: &diagnostics,
+	})
+}
 
-	matches, duration, err := FindMatches(samples, wavInfo.Duration, wavInfo.SampleRate)
+// what recognise does with the samples besides matching them
+type recognition struct {
+	recording   string
+	explain     int // > 0 writes an explanation of that many top candidates to explainPath
+	explainPath string
+	json        bool
+	diagnostics *audio.Diagnostics
+}
+
+// findr -json output
+type Result struct {
+	Recording   string             `json:"recording"`
+	TookMs      int64              `json:"took_ms"`
+	Diagnostics *audio.Diagnostics `json:"diagnostics,omitempty"`
+	Matches     []Match            `json:"matches"`               // top 10, best first
+	Explanation string             `json:"explanation,omitempty"` // path of the -explain report, - for stderr
+}
+
+// match and print, as text or a JSON Result
+func recognise(ctx context.Context, matcher *Matcher, samples []float64, r recognition) error {
+	start := time.Now()
+
+	var matches []Match
+	var explanation *Explanation
+	var err error
+	if r.explain > 0 {
+		matches, explanation, err = matcher.MatchExplained(ctx, samples, audio.TARGET_SAMPLE_RATE, r.explain)
+	} else {
+		matches, err = matcher.Match(ctx, samples, audio.TARGET_SAMPLE_RATE)
+	}
 	if err != nil {
 		log.Logger.WithError(err).Error("error finding samples")
 		return err
 	}
+	took := time.Since(start)
+
+	explainPath := r.explainPath
+	if explanation != nil {
+		explanation.Recording = r.recording
+		explanation.Diagnostics = r.diagnostics
+		if err := WriteExplanation(explainPath, explanation); err != nil {
+			log.Logger.WithError(err).WithField("path", explainPath).Error("Failed to write explanation")
+			explainPath = ""
+		}
+	}
 
-	if len(matches) == 0 {
-		log.Logger.Error("No Matches")
+	if r.json {
+		return printResult(Result{
+			Recording:   r.recording,
+			TookMs:      took.Milliseconds(),
+			Diagnostics: r.diagnostics,
+			Matches:     topMatches(matches),
+			Explanation: explainPath,
+		})
+	}
+
+	if r.diagnostics != nil {
+		printDiagnostics(*r.diagnostics)
+	}
+	err = printMatches(matches, took)
+	if explanation != nil {
+		printExplanation(explanation, explainPath)
+	}
+	return err
+}
+
+// the warnings go to the log so they show up even with -json, which moves the log to stderr
+func warnDiagnostics(d audio.Diagnostics) {
+	for _, warning := range d.Warnings {
+		log.Logger.Warn(warning)
+	}
+}
+
+func printDiagnostics(d audio.Diagnostics) {
+	noise := "broadband"
+	if d.NoiseFrequency > 0 {
+		noise = fmt.Sprintf("%.0f Hz", d.NoiseFrequency)
+	}
+	fmt.Printf("Recording -> RMS: %.1f dBFS | peak: %.1f dBFS | clipped: %.2f%% | silence: %.0f%% | noise: %.1f dBFS (%s)\n\n",
+		d.RMSDbfs, d.PeakDbfs, d.ClippedPercent, 100*d.SilenceFraction, d.NoiseFloorDbfs, noise)
+}
+
+// an empty match list is still a result, it's only an error for the text output
+func printResult(result Result) error {
+	if result.Matches == nil {
+		result.Matches = []Match{}
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	if len(result.Matches) == 0 {
 		return errors.New("NO MATCHES FOUND")
 	}
+	return nil
+}
 
-	topMatches := matches
+func topMatches(matches []Match) []Match {
 	if len(matches) > 10 {
-		topMatches = matches[:10]
+		return matches[:10]
 	}
+	return matches
+}
+
+// top 10 + the final prediction
+func printMatches(matches []Match, duration time.Duration) error {
+	if len(matches) == 0 {
+		log.Logger.Error("No Matches")
+		return errors.New("NO MATCHES FOUND")
+	}
+
+	top := topMatches(matches)
 	fmt.Println("Top Matches ->")
-	for _, match := range topMatches {
-		fmt.Printf("\t- %s by %s, score: %.2f\n", match.SongTitle, match.SongArtist, match.Score)
+	for _, match := range top {
+		fmt.Printf("\t- %s by %s, score: %.2f%s\n", match.SongTitle, match.SongArtist, match.Score, shiftInfo(match))
 	}
 
 	fmt.Printf("\nSearch took: %s\n", duration)
-	res := topMatches[0]
-	fmt.Printf("\nFinal prediction: %s by %s , score: %.2f\n", res.SongTitle, res.SongArtist, res.Score)
+	res := top[0]
+	fmt.Printf("\nFinal prediction: %s by %s , score: %.2f%s\n", res.SongTitle, res.SongArtist, res.Score, shiftInfo(res))
+	printSongDetails(res)
 
 	return nil
 }
+
+// whatever was stored about the predicted song beyond title/artist
+func printSongDetails(match Match) {
+	if len(match.SongArtists) > 1 {
+		fmt.Printf("\tArtists: %s\n", strings.Join(match.SongArtists, ", "))
+	}
+	if match.SongAlbum != "" {
+		fmt.Printf("\tAlbum: %s\n", match.SongAlbum)
+	}
+	if match.SongDuration > 0 {
+		fmt.Printf("\tDuration: %s\n", time.Duration(match.SongDuration)*time.Second)
+	}
+	if match.SourceURL != "" {
+		fmt.Printf("\tSource: %s\n", match.SourceURL)
+	}
+	if match.YouTubeID != "" {
+		fmt.Printf("\tYouTube: https://www.youtube.com/watch?v=%s\n", match.YouTubeID)
+	}
+}
+
+// only worth printing when tempo tolerant matching found the query sped up/pitched
+func shiftInfo(match Match) string {
+	if math.Abs(match.SpeedFactor-1) < 0.005 && math.Abs(match.PitchFactor-1) < 0.005 {
+		return ""
+	}
+	return fmt.Sprintf(" (speed: x%.3f, pitch: x%.3f)", match.SpeedFactor, match.PitchFactor)
+}
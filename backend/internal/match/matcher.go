@@ -0,0 +1,95 @@
+package match
+
+import (
+	"context"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+/*
+matches queries against one db connection (pool) for as long as it lives,
+instead of FindMatches' connect-match-disconnect.
+
+nothing in it changes after NewMatcher, so many goroutines (monitored sources, requests
+to a server) can share one
+*/
+type Matcher struct {
+	db   db.DbClient
+	opts Options
+}
+
+// the matcher doesn't own client, closing it is still the caller's job
+func NewMatcher(client db.DbClient, opts Options) *Matcher {
+	return &Matcher{db: client, opts: opts}
+}
+
+func (m *Matcher) Options() Options {
+	return m.opts
+}
+
+// ranked matches for mono samples, stops early with ctx's error when it's cancelled or times out
+func (m *Matcher) Match(ctx context.Context, samples []float64, sampleRate int) ([]Match, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	variants, err := m.query(ctx, samples, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, _, err := m.findMatchesFromDb(ctx, variants)
+	if err != nil {
+		log.Logger.WithError(err).Error("error finding matches")
+		return nil, err
+	}
+	return matches, nil
+}
+
+// Match, plus an explanation of the top candidates
+func (m *Matcher) MatchExplained(ctx context.Context, samples []float64, sampleRate int, top int) ([]Match, *Explanation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	variants, err := m.query(ctx, samples, sampleRate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matches, found, err := m.findMatchesFromDb(ctx, variants)
+	if err != nil {
+		log.Logger.WithError(err).Error("error finding matches")
+		return nil, nil, err
+	}
+	return matches, explain(variants, found, matches, top), nil
+}
+
+func (m *Matcher) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.opts.Timeout > 0 {
+		return context.WithTimeout(ctx, m.opts.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// samples -> the hashes to look up
+func (m *Matcher) query(ctx context.Context, samples []float64, sampleRate int) ([]queryVariant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	samples = m.opts.Preprocess.Apply(samples, sampleRate)
+
+	spectrogram, err := fingerprintalgorithm.Spectrogram(samples, sampleRate)
+	if err != nil {
+		log.Logger.WithError(err).Error("error finding samples")
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	peaks := fingerprintalgorithm.GetPeaksFromSpectrogram(spectrogram, sampleRate)
+	return queryVariants(peaks, m.opts), nil
+}
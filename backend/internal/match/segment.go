@@ -0,0 +1,258 @@
+package match
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+// how the window slides over a long recording
+type SegmentOptions struct {
+	Window   time.Duration
+	Hop      time.Duration
+	MinScore float64 // below this the window counts as unidentified
+	MaxGap   int     // unidentified windows allowed inside one song before it gets split
+	Matching Options
+}
+
+func DefaultSegmentOptions() SegmentOptions {
+	return SegmentOptions{
+		Window:   15 * time.Second,
+		Hop:      5 * time.Second,
+		MinScore: 20,
+		MaxGap:   1,
+		Matching: DefaultOptions(),
+	}
+}
+
+// one song (or one unidentified stretch, SongID 0) on the timeline
+type TimelineEntry struct {
+	Start      float64 `json:"start"` // seconds
+	End        float64 `json:"end"`
+	SongID     uint32  `json:"song_id,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	Artist     string  `json:"artist,omitempty"`
+	Confidence float64 `json:"confidence"`
+	Score      float64 `json:"score"`
+	Windows    int     `json:"windows"`
+	Speed      float64 `json:"speed,omitempty"` // with tempo tolerant matching
+}
+
+type Timeline struct {
+	File    string          `json:"file"`
+	Length  float64         `json:"length"`
+	Window  float64         `json:"window"`
+	Hop     float64         `json:"hop"`
+	Entries []TimelineEntry `json:"entries"`
+}
+
+// result for a single window, before merging
+type windowMatch struct {
+	start, end float64
+	match      *Match
+	confidence float64
+}
+
+// identify the song in every window of a DJ set / radio capture and merge them into a timeline
+func SegmentFile(ctx context.Context, filePath string, opts SegmentOptions) (*Timeline, error) {
+	if opts.Window <= 0 || opts.Hop <= 0 || opts.Hop > opts.Window {
+		return nil, fmt.Errorf("need 0 < hop <= window, window: %s | hop: %s", opts.Window, opts.Hop)
+	}
+
+	samples, err := audio.Load(filePath)
+	if err != nil {
+		log.Logger.WithError(err).WithField("file", filePath).Error("Could not read audio file")
+		return nil, err
+	}
+
+	client, err := db.NewDbClient()
+	if err != nil {
+		log.Logger.WithError(err).Error("error connecting to db")
+		return nil, err
+	}
+	defer client.Close()
+	matcher := NewMatcher(client, opts.Matching)
+
+	sampleRate := audio.TARGET_SAMPLE_RATE
+	length := float64(len(samples)) / float64(sampleRate)
+	windowSize := int(opts.Window.Seconds() * float64(sampleRate))
+	hopSize := int(opts.Hop.Seconds() * float64(sampleRate))
+
+	log.Logger.WithFields(logrus.Fields{
+		"file":   filePath,
+		"length": time.Duration(length * float64(time.Second)).Truncate(time.Second),
+		"window": opts.Window,
+		"hop":    opts.Hop,
+	}).Info("Segmenting recording")
+
+	var windows []windowMatch
+	for start := 0; start < len(samples); start += hopSize {
+		end := start + windowSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		// a short tail is covered by the previous window anyway
+		if start > 0 && end-start < hopSize {
+			break
+		}
+
+		matches, err := matcher.Match(ctx, samples[start:end], sampleRate)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			// a window that timed out (or failed) is just unidentified, the rest of the timeline still counts
+			log.Logger.WithError(err).WithField("start", time.Duration(float64(start)/float64(sampleRate)*float64(time.Second))).Warn("Recognition failed for window")
+			matches = nil
+		}
+
+		/*
+			each window is attributed to the hop-sized slice around its center,
+			so overlapping windows don't claim the same seconds twice
+		*/
+		center := (float64(start) + float64(end-start)/2) / float64(sampleRate)
+		w := windowMatch{
+			start: max(0, center-opts.Hop.Seconds()/2),
+			end:   min(length, center+opts.Hop.Seconds()/2),
+		}
+		if len(windows) == 0 {
+			w.start = 0
+		}
+		if len(matches) > 0 && matches[0].Score >= opts.MinScore {
+			best := matches[0]
+			w.match = &best
+			w.confidence = Confidence(matches)
+		}
+		windows = append(windows, w)
+
+		log.Logger.WithFields(logrus.Fields{
+			"start":      w.start,
+			"identified": w.match != nil,
+		}).Debug("Segmented window")
+	}
+	if len(windows) > 0 {
+		windows[len(windows)-1].end = length
+	}
+
+	return &Timeline{
+		File:    filePath,
+		Length:  length,
+		Window:  opts.Window.Seconds(),
+		Hop:     opts.Hop.Seconds(),
+		Entries: mergeWindows(windows, opts.MaxGap),
+	}, nil
+}
+
+// collapse neighbouring windows of the same song into one entry
+func mergeWindows(windows []windowMatch, maxGap int) []TimelineEntry {
+	var entries []TimelineEntry
+	for _, w := range windows {
+		var songID uint32
+		if w.match != nil {
+			songID = w.match.SongID
+		}
+
+		if len(entries) > 0 && entries[len(entries)-1].SongID == songID {
+			last := &entries[len(entries)-1]
+			last.End = w.end
+			if w.match != nil {
+				last.Confidence = (last.Confidence*float64(last.Windows) + w.confidence) / float64(last.Windows+1)
+				last.Score = max(last.Score, w.match.Score)
+			}
+			last.Windows++
+			continue
+		}
+
+		entry := TimelineEntry{Start: w.start, End: w.end, Windows: 1}
+		if w.match != nil {
+			entry.SongID = w.match.SongID
+			entry.Title = w.match.SongTitle
+			entry.Artist = w.match.SongArtist
+			entry.Confidence = w.confidence
+			entry.Score = w.match.Score
+			if speed := w.match.SpeedFactor; math.Abs(speed-1) >= 0.005 {
+				entry.Speed = speed
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	// short unidentified dips inside a song (a transition, crowd noise) don't split it
+	var merged []TimelineEntry
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if entry.SongID == 0 && entry.Windows <= maxGap && len(merged) > 0 && i+1 < len(entries) &&
+			entries[i+1].SongID == merged[len(merged)-1].SongID {
+			last := &merged[len(merged)-1]
+			next := entries[i+1]
+			last.End = next.End
+			last.Confidence = (last.Confidence*float64(last.Windows) + next.Confidence*float64(next.Windows)) / float64(last.Windows+next.Windows)
+			last.Score = max(last.Score, next.Score)
+			last.Windows += next.Windows
+			i++
+			continue
+		}
+		merged = append(merged, entry)
+	}
+
+	return merged
+}
+
+func WriteTimelineJSON(w io.Writer, timeline *Timeline) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(timeline)
+}
+
+// cue sheet with one TRACK per identified song, INDEX is mm:ss:ff with 75 frames a second
+func WriteCueSheet(w io.Writer, timeline *Timeline) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "REM GENERATOR \"FINDR\"\n")
+	fmt.Fprintf(&b, "TITLE %s\n", cueString(strings.TrimSuffix(filepath.Base(timeline.File), filepath.Ext(timeline.File))))
+	fmt.Fprintf(&b, "FILE %s %s\n", cueString(filepath.Base(timeline.File)), cueFileType(timeline.File))
+
+	track := 0
+	for _, entry := range timeline.Entries {
+		if entry.SongID == 0 {
+			continue
+		}
+		track++
+		frames := int(entry.Start * 75)
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", track)
+		fmt.Fprintf(&b, "    TITLE %s\n", cueString(entry.Title))
+		fmt.Fprintf(&b, "    PERFORMER %s\n", cueString(entry.Artist))
+		fmt.Fprintf(&b, "    REM CONFIDENCE %.2f\n", entry.Confidence)
+		fmt.Fprintf(&b, "    INDEX 01 %02d:%02d:%02d\n", frames/75/60, frames/75%60, frames%75)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// cue sheets have no escapes, a double quote inside a value would end it early
+func cueString(value string) string {
+	value = strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(value)
+	return `"` + value + `"`
+}
+
+// the FILE type, cue sheets only tell apart MP3, AIFF and everything else as WAVE
+func cueFileType(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp3":
+		return "MP3"
+	case ".aif", ".aiff":
+		return "AIFF"
+	}
+	return "WAVE"
+}
@@ -0,0 +1,233 @@
+package match
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/wav"
+)
+
+// shortest tail of a stream that is still worth running through the matcher
+const MIN_SEGMENT_TIME = 3 * time.Second
+
+// how to read audio from a pipe
+type StreamOptions struct {
+	Format     string // "s16le", "f32le" or "wav"
+	SampleRate int    // ignored for wav, the header has it
+	Channels   int    // ignored for wav, the header has it
+	Duration   time.Duration
+	Segment    time.Duration // > 0 means keep recognising every Segment until EOF
+	Matching   Options
+	Explain    int    // > 0 writes an explanation of that many top candidates
+	ExplainOut string // where the explanation goes, stderr when empty since there's no recording
+	JSON       bool   // print a Result as JSON, without -segment
+}
+
+func DefaultStreamOptions() StreamOptions {
+	return StreamOptions{
+		Format:     "s16le",
+		SampleRate: audio.TARGET_SAMPLE_RATE,
+		Channels:   1,
+		Duration:   RECORDING_TIME * time.Second,
+		Matching:   DefaultOptions(),
+	}
+}
+
+/*
+recognise audio coming from a pipe, e.g.
+
+	ffmpeg -i stream -f s16le -ac 1 -ar 44100 - | findr findr --stdin
+	arecord -f cd -t wav | findr findr --stdin --format wav
+
+never touches malgo or the recordings dir
+*/
+func FindFromStream(ctx context.Context, r io.Reader, opts StreamOptions) error {
+	client, err := db.NewDbClient()
+	if err != nil {
+		log.Logger.WithError(err).Error("error connecting to db")
+		return err
+	}
+	defer client.Close()
+
+	// one matcher (and connection) for the whole stream
+	matcher := NewMatcher(client, opts.Matching)
+
+	return ReadSegments(r, opts, func(offset, length time.Duration, samples []float64) error {
+		if ctx.Err() != nil {
+			return ErrStopReading
+		}
+
+		if opts.Segment <= 0 {
+			explainPath := ""
+			if opts.Explain > 0 {
+				explainPath = opts.ExplainOut
+				if explainPath == "" {
+					explainPath = EXPLAIN_STDERR
+				}
+			}
+			// already mono 44.1kHz, which still shows clipping/silence/noise
+			diagnostics := audio.Analyze(samples, 1, audio.TARGET_SAMPLE_RATE)
+			warnDiagnostics(diagnostics)
+
+			err := recognise(ctx, matcher, samples, recognition{
+				recording:   "stdin",
+				explain:     opts.Explain,
+				explainPath: explainPath,
+				json:        opts.JSON,
+				diagnostics: &diagnostics,
+			})
+			if err != nil {
+				return err
+			}
+			return ErrStopReading
+		}
+
+		matches, err := matcher.Match(ctx, samples, audio.TARGET_SAMPLE_RATE)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ErrStopReading
+			}
+			// one bad segment shouldn't end continuous recognition
+			log.Logger.WithError(err).Warn("Recognition failed for segment")
+			matches = nil
+		}
+		printSegmentMatch(offset, length, matches)
+		return nil
+	})
+}
+
+// returned by a SegmentFunc to stop reading early without it counting as a failure
+var ErrStopReading = errors.New("stop reading")
+
+// a single lookup (no Segment) on a stream that ended before its first sample, nothing to match
+var ErrNoAudio = errors.New("no audio on stdin")
+
+// gets every segment read from a stream, samples are already mono at TARGET_SAMPLE_RATE
+type SegmentFunc func(offset, length time.Duration, samples []float64) error
+
+/*
+cut a stream into opts.Segment long pieces (or a single opts.Duration long one)
+and hand each to fn until EOF
+*/
+func ReadSegments(r io.Reader, opts StreamOptions, fn SegmentFunc) error {
+	br := bufio.NewReader(r)
+
+	var format wav.Format
+	if opts.Format == "wav" {
+		header, _, err := wav.ReadHeader(br)
+		if errors.Is(err, io.EOF) && opts.Segment <= 0 {
+			return ErrNoAudio
+		}
+		if err != nil {
+			log.Logger.WithError(err).Error("Could not read WAV header from stream")
+			return err
+		}
+		format = header
+	} else {
+		raw, err := rawFormat(opts.Format)
+		if err != nil {
+			return err
+		}
+		if opts.SampleRate <= 0 || opts.Channels <= 0 {
+			return fmt.Errorf("sample rate and channels must be above 0, rate: %d | channels: %d", opts.SampleRate, opts.Channels)
+		}
+		format = raw
+		format.SampleRate = opts.SampleRate
+		format.Channels = opts.Channels
+	}
+
+	length := opts.Duration
+	if opts.Segment > 0 {
+		length = opts.Segment
+	}
+	if length <= 0 {
+		return fmt.Errorf("duration must be above 0, got %s", length)
+	}
+
+	frameSize := format.FrameSize()
+	buffer := make([]byte, int(length.Seconds()*float64(format.SampleRate))*frameSize)
+
+	log.Logger.WithFields(logrus.Fields{
+		"format":      opts.Format,
+		"bits":        format.BitsPerSample,
+		"sample_rate": format.SampleRate,
+		"channels":    format.Channels,
+		"segment":     length,
+		"continuous":  opts.Segment > 0,
+	}).Debug("Reading audio stream")
+
+	var offset time.Duration
+	for {
+		n, readErr := io.ReadFull(br, buffer)
+		n -= n % frameSize
+
+		segmentTime := time.Duration(float64(n/frameSize) / float64(format.SampleRate) * float64(time.Second))
+		if segmentTime >= MIN_SEGMENT_TIME || (n > 0 && offset == 0) {
+			samples, err := wav.Decode(format, buffer[:n])
+			if err != nil {
+				return err
+			}
+			samples, err = audio.ToMono(samples, format.Channels, format.SampleRate)
+			if err != nil {
+				return err
+			}
+
+			if err := fn(offset, segmentTime, samples); err != nil {
+				if errors.Is(err, ErrStopReading) {
+					return nil
+				}
+				return err
+			}
+			if opts.Segment <= 0 {
+				return nil
+			}
+		}
+		offset += segmentTime
+
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+				// a single lookup returns as soon as it has samples, getting here means there were none
+				if opts.Segment <= 0 {
+					return ErrNoAudio
+				}
+				log.Logger.WithField("read", offset).Info("Reached end of stream")
+				return nil
+			}
+			log.Logger.WithError(readErr).Error("Failed to read audio stream")
+			return readErr
+		}
+	}
+}
+
+// raw stdin formats as the WAV encoding they'd have, rate and channels come from the flags
+func rawFormat(format string) (wav.Format, error) {
+	switch format {
+	case "s16le":
+		return wav.Format{AudioFormat: wav.FORMAT_PCM, BitsPerSample: 16}, nil
+	case "f32le":
+		return wav.Format{AudioFormat: wav.FORMAT_IEEE_FLOAT, BitsPerSample: 32}, nil
+	default:
+		return wav.Format{}, fmt.Errorf("unsupported raw format %q, expected s16le, f32le or wav", format)
+	}
+}
+
+// one line per segment for continuous recognition
+func printSegmentMatch(offset, length time.Duration, matches []Match) {
+	start := offset.Truncate(time.Second)
+	end := (offset + length).Truncate(time.Second)
+	if len(matches) == 0 {
+		fmt.Printf("[%s - %s] no match\n", start, end)
+		return
+	}
+	best := matches[0]
+	fmt.Printf("[%s - %s] %s by %s, score: %.2f\n", start, end, best.SongTitle, best.SongArtist, best.Score)
+}
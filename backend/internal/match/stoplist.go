@@ -0,0 +1,71 @@
+package match
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+)
+
+/*
+hashes from silence, hum or common chords end up in a good part of the library. Looking them up
+fetches thousands of couples that line up with nothing, so past a number of songs they're
+stop-listed: left out of the lookup, or kept with a smaller weight in the score
+*/
+const (
+	STOPLIST_SKIP   = "skip"
+	STOPLIST_WEIGHT = "weight"
+
+	DEFAULT_STOPLIST_MAX_SONGS = 500 // libraries this small never stop-list anything
+)
+
+// FINDR_STOPLIST_MAX_SONGS and FINDR_STOPLIST_MODE, or the defaults when they're unset or broken.
+// Read (and warned about) once per run
+var stopListFromEnv = sync.OnceValues(func() (int, string) {
+	maxSongs, mode := DEFAULT_STOPLIST_MAX_SONGS, STOPLIST_SKIP
+
+	if value, ok := os.LookupEnv("FINDR_STOPLIST_MAX_SONGS"); ok {
+		parsed, err := strconv.Atoi(value)
+		if err == nil && parsed >= 0 {
+			maxSongs = parsed
+		} else {
+			log.Logger.WithField("env", "FINDR_STOPLIST_MAX_SONGS").Warnf("Invalid stop-list size %q, using %d", value, maxSongs)
+		}
+	}
+	if value, ok := os.LookupEnv("FINDR_STOPLIST_MODE"); ok {
+		if err := ValidateStopListMode(value); err == nil {
+			mode = value
+		} else {
+			log.Logger.WithError(err).WithField("env", "FINDR_STOPLIST_MODE").Warnf("Using %s", mode)
+		}
+	}
+
+	return maxSongs, mode
+})
+
+func ValidateStopListMode(mode string) error {
+	if mode != STOPLIST_SKIP && mode != STOPLIST_WEIGHT {
+		return fmt.Errorf("unknown stop-list mode %q, expected %s or %s", mode, STOPLIST_SKIP, STOPLIST_WEIGHT)
+	}
+	return nil
+}
+
+// hash -> songs it occurs in, for the query hashes that are stop-listed
+func (m *Matcher) stopListed(ctx context.Context, hashes []uint32) (map[uint32]int, error) {
+	if m.opts.MaxAddressSongs <= 0 {
+		return nil, nil
+	}
+	return m.db.FrequentAddresses(ctx, hashes, m.opts.MaxAddressSongs)
+}
+
+// what a hash's couples count for in the score, 1 unless it's stop-listed and weighted
+func (m *Matcher) hashWeight(stopListed map[uint32]int, hash uint32) float64 {
+	songs, ok := stopListed[hash]
+	if !ok || m.opts.StopList != STOPLIST_WEIGHT {
+		return 1
+	}
+	return float64(m.opts.MaxAddressSongs) / float64(songs)
+}
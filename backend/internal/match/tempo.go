@@ -0,0 +1,156 @@
+package match
+
+import (
+	"math"
+	"math/rand"
+
+	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+)
+
+const (
+	// frequency indices have 9 bits in the hash
+	maxFreqIdx = 1 << 9
+
+	stretchBinWidth  = 0.005 // resolution of the slope histogram
+	minStretchSpanMs = 1000  // pairs closer than this in the query give too noisy a slope
+	maxStretchPoints = 1500  // couples used for the slope histogram, it's O(N^2)
+)
+
+// the query's hashes as they would look at one pitch factor
+type queryVariant struct {
+	pitch  float64
+	hashes map[uint32]uint32 // hash -> sample anchor time
+}
+
+/*
+a sped-up or pitched query has every frequency index (and every time delta) scaled,
+so none of its hashes line up with the original. To still find it, the query is also hashed
+as if it was played back at each candidate factor: frequencies divided, times multiplied.
+
+without TempoTolerant that's just the query as recorded.
+*/
+func queryVariants(peaks []fingerprintalgorithm.Peak, opts Options) []queryVariant {
+	factors := []float64{1}
+	if opts.TempoTolerant && opts.ShiftStep > 0 {
+		for shift := opts.ShiftStep; shift <= opts.MaxShift+1e-9; shift += opts.ShiftStep {
+			factors = append(factors, 1+shift, 1/(1+shift))
+		}
+	}
+
+	variants := make([]queryVariant, 0, len(factors))
+	for _, factor := range factors {
+		shifted := peaks
+		if factor != 1 {
+			shifted = make([]fingerprintalgorithm.Peak, 0, len(peaks))
+			for _, peak := range peaks {
+				freqIdx := int(math.Round(float64(peak.FreqIdx) / factor))
+				if freqIdx >= maxFreqIdx {
+					continue
+				}
+				shifted = append(shifted, fingerprintalgorithm.Peak{Time: peak.Time * factor, FreqIdx: freqIdx})
+			}
+		}
+
+		hashes := make(map[uint32]uint32)
+		for hash, couple := range fingerprintalgorithm.Fingerprint(shifted, rand.Uint32()) {
+			hashes[hash] = couple.AnchorTimeMs
+		}
+		variants = append(variants, queryVariant{pitch: factor, hashes: hashes})
+	}
+
+	return variants
+}
+
+/*
+the linear time stretch between query and song, i.e. the slope of dbTime against sampleTime,
+and the indices of the couples that line up on that line.
+
+random hash collisions make a plain regression useless, so the dominant slope between pairs
+of couples is found with a histogram first, then refined with a least squares fit over
+only the couples that line up with it
+*/
+func estimateStretch(times [][2]uint32, maxShift float64) (float64, []int) {
+	lo, hi := 1/(1+2*maxShift), 1+2*maxShift
+	bins := make([]int, int((hi-lo)/stretchBinWidth)+1)
+
+	step := 1
+	if len(times) > maxStretchPoints {
+		step = len(times) / maxStretchPoints
+	}
+	for i := 0; i < len(times); i += step {
+		for j := i + step; j < len(times); j += step {
+			sampleDiff := float64(times[j][0]) - float64(times[i][0])
+			if math.Abs(sampleDiff) < minStretchSpanMs {
+				continue
+			}
+			slope := (float64(times[j][1]) - float64(times[i][1])) / sampleDiff
+			if slope < lo || slope > hi {
+				continue
+			}
+			bins[int((slope-lo)/stretchBinWidth)]++
+		}
+	}
+
+	best := -1
+	for i, count := range bins {
+		if count > 0 && (best < 0 || count > bins[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 1, nil
+	}
+	slope := lo + (float64(best)+0.5)*stretchBinWidth
+
+	// most common offset of the song in the query at that slope
+	offsets := make(map[int64]int)
+	bestOffset := int64(0)
+	for _, t := range times {
+		bin := int64(math.Round((float64(t[1]) - slope*float64(t[0])) / TOLERANCE))
+		offsets[bin]++
+		if offsets[bin] > offsets[bestOffset] || (offsets[bin] == offsets[bestOffset] && bin < bestOffset) {
+			bestOffset = bin
+		}
+	}
+	offset := float64(bestOffset) * TOLERANCE
+
+	var inliers []int
+	var n, sumX, sumY, sumXX, sumXY float64
+	for i, t := range times {
+		x, y := float64(t[0]), float64(t[1])
+		if math.Abs(y-(slope*x+offset)) > TOLERANCE {
+			continue
+		}
+		inliers = append(inliers, i)
+		n++
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if n < 2 || denominator == 0 {
+		return slope, inliers
+	}
+	fitted := (n*sumXY - sumX*sumY) / denominator
+	if fitted < lo || fitted > hi {
+		return slope, inliers
+	}
+	return fitted, inliers
+}
+
+/*
+every pair of couples on the same line is aligned, so this is on the same scale as the
+exact pairwise score without being O(N^2) for each of the many query variants.
+A pair counts for the product of its couples' weights (all 1 without the weighted stop-list),
+summed over pairs that's ((sum w)^2 - sum w^2) / 2
+*/
+func stretchedScore(inliers []int, weights []float64) float64 {
+	var sum, sumSquares float64
+	for _, i := range inliers {
+		sum += weights[i]
+		sumSquares += weights[i] * weights[i]
+	}
+	return (sum*sum - sumSquares) / 2
+}
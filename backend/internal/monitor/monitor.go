@@ -0,0 +1,239 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/match"
+)
+
+type Options struct {
+	Segment    time.Duration // length of audio recognised at a time
+	MinScore   float64       // below this a segment counts as no match
+	MaxGap     int           // unmatched segments allowed before a play is considered over
+	MinPlay    int           // matched segments needed before a play gets logged
+	RetryDelay time.Duration // wait before reopening a dropped stream
+	Matching   match.Options
+}
+
+func DefaultOptions() Options {
+	// a lookup slower than the segment it's for would leave the monitor falling behind
+	matching := match.DefaultOptions()
+	matching.Timeout = 10 * time.Second
+
+	return Options{
+		Segment:    10 * time.Second,
+		MinScore:   20,
+		MaxGap:     1,
+		MinPlay:    2,
+		RetryDelay: 5 * time.Second,
+		Matching:   matching,
+	}
+}
+
+/*
+runs continuous recognition on every source until ctx is cancelled
+(or, for files and pipes, until they run out) and logs airplay events to the db
+*/
+func Run(ctx context.Context, sources []string, opts Options) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no sources to monitor")
+	}
+	if opts.Segment < match.MIN_SEGMENT_TIME {
+		return fmt.Errorf("segment must be at least %s, got %s", match.MIN_SEGMENT_TIME, opts.Segment)
+	}
+
+	client, err := db.NewDbClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// shared by every source
+	matcher := match.NewMatcher(client, opts.Matching)
+
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source string) {
+			defer wg.Done()
+			monitorSource(ctx, client, matcher, source, opts)
+		}(source)
+	}
+	wg.Wait()
+
+	log.Logger.Info("Monitoring stopped")
+	return nil
+}
+
+func monitorSource(ctx context.Context, client db.DbClient, matcher *match.Matcher, source string, opts Options) {
+	logger := log.Logger.WithField("source", source)
+	t := &tracker{source: source, opts: opts, client: client, inFile: !isLive(source)}
+
+	for {
+		logger.Info("Monitoring source")
+
+		err := readSource(ctx, source, opts, func(offset, length time.Duration, samples []float64) error {
+			if ctx.Err() != nil {
+				return match.ErrStopReading
+			}
+			// the segment has only just finished arriving, before the lookup adds its own delay
+			seg := segment{read: time.Now(), offset: offset, length: length}
+
+			matches, err := matcher.Match(ctx, samples, audio.TARGET_SAMPLE_RATE)
+			if err != nil {
+				if ctx.Err() != nil {
+					return match.ErrStopReading
+				}
+				// a failed lookup shouldn't kill a long running monitor
+				logger.WithError(err).Warn("Recognition failed for segment")
+				matches = nil
+			}
+
+			t.observe(matches, seg)
+			return nil
+		})
+		if err != nil {
+			logger.WithError(err).Warn("Source stopped")
+		}
+
+		// whatever was playing when the source ended/dropped is done
+		t.flush()
+
+		if !isStream(source) || ctx.Err() != nil {
+			return
+		}
+
+		logger.WithField("retry_in", opts.RetryDelay).Info("Reopening stream")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(opts.RetryDelay):
+		}
+	}
+}
+
+func readSource(ctx context.Context, source string, opts Options, fn match.SegmentFunc) error {
+	reader, streamOpts, err := openSource(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	streamOpts.Segment = opts.Segment
+	readErr := match.ReadSegments(reader, streamOpts, fn)
+	closeErr := reader.Close()
+
+	if readErr != nil {
+		return readErr
+	}
+	if ctx.Err() != nil {
+		// ffmpeg got killed by the context, not an error
+		return nil
+	}
+	return closeErr
+}
+
+// when a segment was read and where it is in its source
+type segment struct {
+	read   time.Time
+	offset time.Duration
+	length time.Duration
+}
+
+// turns a sequence of per-segment matches into airplay events for one source
+type tracker struct {
+	source string
+	opts   Options
+	client db.DbClient
+	inFile bool // plays are placed by their offset, not by when they were read
+
+	current  *db.AirplayEvent
+	segments int // matched segments in current
+	missed   int // unmatched segments since the last match
+}
+
+func (t *tracker) observe(matches []match.Match, seg segment) {
+	var best *match.Match
+	if len(matches) > 0 && matches[0].Score >= t.opts.MinScore {
+		best = &matches[0]
+	}
+
+	if best == nil {
+		if t.current != nil {
+			t.missed++
+			if t.missed > t.opts.MaxGap {
+				t.flush()
+			}
+		}
+		return
+	}
+
+	confidence := match.Confidence(matches)
+	// live audio played as it arrived, a file's segments were read in one go
+	start, end := seg.read.Add(-seg.length), seg.read
+	if t.inFile {
+		start = seg.read
+	}
+
+	if t.current != nil && t.current.SongID == best.SongID {
+		t.current.End = end
+		t.current.FileEnd = seg.offset + seg.length
+		t.current.Confidence = (t.current.Confidence*float64(t.segments) + confidence) / float64(t.segments+1)
+		t.current.Score = max(t.current.Score, best.Score)
+		t.segments++
+		t.missed = 0
+		return
+	}
+
+	t.flush()
+	t.current = &db.AirplayEvent{
+		Source:     t.source,
+		SongID:     best.SongID,
+		Title:      best.SongTitle,
+		Artist:     best.SongArtist,
+		Start:      start,
+		End:        end,
+		Confidence: confidence,
+		Score:      best.Score,
+		InFile:     t.inFile,
+		FileStart:  seg.offset,
+		FileEnd:    seg.offset + seg.length,
+	}
+	t.segments = 1
+	t.missed = 0
+}
+
+// log the current play (if it lasted long enough) and reset
+func (t *tracker) flush() {
+	event := t.current
+	segments := t.segments
+	t.current, t.segments, t.missed = nil, 0, 0
+
+	if event == nil || segments < t.opts.MinPlay {
+		return
+	}
+
+	fields := logrus.Fields{
+		"source":     event.Source,
+		"title":      event.Title,
+		"artist":     event.Artist,
+		"start":      event.Start.Format(time.RFC3339),
+		"duration":   event.Length().Truncate(time.Second),
+		"confidence": fmt.Sprintf("%.2f", event.Confidence),
+	}
+	if event.InFile {
+		fields["at"] = event.FileStart.Truncate(time.Second)
+	}
+	if err := t.client.StoreAirplay(*event); err != nil {
+		log.Logger.WithError(err).WithFields(fields).Error("Failed to store airplay event")
+		return
+	}
+	log.Logger.WithFields(fields).Info("Logged airplay")
+}
@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+)
+
+// per song totals for the summary report
+type songPlays struct {
+	SongID  uint32
+	Title   string
+	Artist  string
+	Plays   int
+	Airtime time.Duration
+	Last    time.Time
+}
+
+// every logged play in the filter's window, or one line per song with summary
+func PrintReport(w io.Writer, filter db.AirplayFilter, summary bool) error {
+	client, err := db.NewDbClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	events, err := client.GetAirplay(filter)
+	if err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		_, err := fmt.Fprintln(w, "No airplay logged")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if summary {
+		fmt.Fprintln(tw, "PLAYS\tAIRTIME\tLAST PLAYED\tTITLE\tARTIST")
+		for _, s := range summarise(events) {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", s.Plays, s.Airtime.Truncate(time.Second), s.Last.Local().Format(time.DateTime), s.Title, s.Artist)
+		}
+	} else {
+		fmt.Fprintln(tw, "START\tEND\tSOURCE\tIN FILE\tTITLE\tARTIST\tCONFIDENCE")
+		for _, e := range events {
+			position := "-"
+			if e.InFile {
+				position = fmt.Sprintf("%s-%s", e.FileStart.Truncate(time.Second), e.FileEnd.Truncate(time.Second))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%.2f\n", e.Start.Local().Format(time.DateTime), e.End.Local().Format(time.TimeOnly), e.Source, position, e.Title, e.Artist, e.Confidence)
+		}
+	}
+	return tw.Flush()
+}
+
+// most played first
+func summarise(events []db.AirplayEvent) []songPlays {
+	bySong := map[uint32]*songPlays{}
+	for _, e := range events {
+		s, ok := bySong[e.SongID]
+		if !ok {
+			s = &songPlays{SongID: e.SongID, Title: e.Title, Artist: e.Artist}
+			bySong[e.SongID] = s
+		}
+		s.Plays++
+		s.Airtime += e.Length()
+		if e.Start.After(s.Last) {
+			s.Last = e.Start
+		}
+	}
+
+	result := make([]songPlays, 0, len(bySong))
+	for _, s := range bySong {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Plays != result[j].Plays {
+			return result[i].Plays > result[j].Plays
+		}
+		return result[i].Airtime > result[j].Airtime
+	})
+	return result
+}
@@ -0,0 +1,121 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/wav"
+)
+
+// audio sent to a client at a time, then it waits for that long to pass
+const serveChunk = 100 * time.Millisecond
+
+/*
+stands in for an internet radio station: streams a WAV file over http on a loop, at the speed it plays,
+until ctx is cancelled. A static file server won't do since it sends the whole file at once.
+Every client starts from the beginning of the file
+*/
+func Serve(ctx context.Context, addr, filePath string) error {
+	header, data, format, err := loadServed(filePath)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		logger := log.Logger.WithField("client", r.RemoteAddr)
+		logger.Info("Client connected")
+		streamWav(r.Context(), w, header, data, format)
+		logger.Info("Client disconnected")
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Logger.WithFields(logrus.Fields{
+		"addr":        addr,
+		"file":        filePath,
+		"sample_rate": format.SampleRate,
+		"channels":    format.Channels,
+	}).Info("Serving stream")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving stream: %w", err)
+	}
+	return nil
+}
+
+/*
+the file's header, with its sizes set to 0xFFFFFFFF like any endless WAV stream
+(readers would stop after one pass otherwise), and its samples
+*/
+func loadServed(filePath string) ([]byte, []byte, wav.Format, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, wav.Format{}, err
+	}
+
+	reader := bytes.NewReader(raw)
+	format, dataSize, err := wav.ReadHeader(reader)
+	if err != nil {
+		return nil, nil, wav.Format{}, fmt.Errorf("error reading %s, only WAV files can be served: %w", filePath, err)
+	}
+	headerSize := len(raw) - reader.Len()
+
+	data := raw[headerSize:]
+	if int(dataSize) < len(data) {
+		data = data[:dataSize]
+	}
+	data = data[:len(data)-len(data)%format.FrameSize()]
+	if len(data) == 0 {
+		return nil, nil, wav.Format{}, fmt.Errorf("%s has no samples", filePath)
+	}
+
+	// the RIFF size, and the data chunk's size which ReadHeader read last
+	header := bytes.Clone(raw[:headerSize])
+	binary.LittleEndian.PutUint32(header[4:8], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(header[headerSize-4:], 0xFFFFFFFF)
+	return header, data, format, nil
+}
+
+// writes the header then the samples on a loop, serveChunk at a time, until the client goes away
+func streamWav(ctx context.Context, w http.ResponseWriter, header, data []byte, format wav.Format) {
+	chunk := max(1, int(serveChunk.Seconds()*float64(format.SampleRate))) * format.FrameSize()
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Set("Content-Type", "audio/wav")
+	if _, err := w.Write(header); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(serveChunk)
+	defer ticker.Stop()
+
+	for position := 0; ; {
+		end := min(position+chunk, len(data))
+		if _, err := w.Write(data[position:end]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		position = end % len(data)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package monitor
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	"github.com/ONESHO1/FINDR/backend/internal/match"
+)
+
+// http(s) streams drop, so they get reopened, files and pipes are read once
+func isStream(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+/*
+http streams and pipes deliver audio as it plays, so a segment played when it was read.
+Files are decoded far faster than realtime, their plays are placed by their offset instead
+*/
+func isLive(source string) bool {
+	if isStream(source) {
+		return true
+	}
+	info, err := os.Stat(source)
+	return err == nil && !info.Mode().IsRegular()
+}
+
+/*
+WAV files (or named pipes carrying WAV) are read directly,
+anything else (mp3/aac files, http streams, ...) is decoded by ffmpeg into raw mono PCM
+*/
+func openSource(ctx context.Context, source string) (io.ReadCloser, match.StreamOptions, error) {
+	opts := match.DefaultStreamOptions()
+
+	if !isStream(source) && strings.EqualFold(filepath.Ext(source), ".wav") {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, opts, err
+		}
+		opts.Format = "wav"
+		return file, opts, nil
+	}
+
+	reader, err := audio.FfmpegDecode(ctx, source)
+	if err != nil {
+		return nil, opts, err
+	}
+
+	opts.Format = audio.FFMPEG_FORMAT
+	opts.SampleRate = audio.TARGET_SAMPLE_RATE
+	opts.Channels = 1
+	return reader, opts, nil
+}
@@ -13,17 +13,15 @@ import (
 	"github.com/sirupsen/logrus"
 
 	db "github.com/ONESHO1/FINDR/backend/internal/db"
-	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
 	"github.com/ONESHO1/FINDR/backend/internal/log"
 	sp "github.com/ONESHO1/FINDR/backend/internal/spotify"
 	"github.com/ONESHO1/FINDR/backend/internal/utils"
-	"github.com/ONESHO1/FINDR/backend/internal/wav"
 	yt "github.com/ONESHO1/FINDR/backend/internal/youtube"
 )
 
 const SONGS_DIRECTORY string = "songs"
 
-func download(tracks []sp.Track, path string) error {
+func download(tracks []sp.Track, path string, opts AddOptions) error {
 	/* WaitGroup is a synchronization tool used
 	to wait for a collection of goroutines to finish.
 	TS is basically a counter.
@@ -93,6 +91,7 @@ func download(tracks []sp.Track, path string) error {
 				Artists:  track.Artists,
 				Duration: track.Duration,
 				Title:    track.Title,
+				ID:       track.ID,
 			}
 
 			// check if song exists in DB
@@ -145,95 +144,25 @@ func download(tracks []sp.Track, path string) error {
 				return
 			}
 
-			// convert to wav file (single channel)
-			wavFilePath, err := wav.ConvertToWav(filePath, 1)
+			// decode to mono samples at 44.1kHz (ffmpeg for the m4a)
+			samples, err := loadSong(filePath)
 			if err != nil {
 				log.Logger.WithFields(logrus.Fields{
 					"title":  tmpTrack.Title,
 					"artist": tmpTrack.Artist,
 					"file":   filePath,
 					"error":  err,
-				}).Error("Processing failed at WAV conversion step")
+				}).Error("Processing failed at audio decoding step")
 				return
 			}
 
-			// read the wav file info
-			wavInfo, err := wav.WavInfo(wavFilePath)
-			if err != nil {
-				log.Logger.WithFields(logrus.Fields{
-					"title":    tmpTrack.Title,
-					"artist":   tmpTrack.Artist,
-					"file":     filePath,
-					"wav file": wavFilePath,
-					"error":    err,
-				}).Error("Could'nt get the WAV info from header")
-				return
-			}
-
-			// convert the wav bytes into samples
-			samples, err := wav.Samples(wavInfo.Data)
-			if err != nil {
-				log.Logger.WithFields(logrus.Fields{
-					"title":    tmpTrack.Title,
-					"artist":   tmpTrack.Artist,
-					"file":     filePath,
-					"wav file": wavFilePath,
-					"error":    err,
-				}).Error("Error converting WAV bytes to samples")
-				return
-			}
-			// fmt.Println(samples)
-
-			// Register songs
-			songID, err := db.RegisterSong(track.Title, track.Artist)
-			if err != nil {
-				log.Logger.WithFields(logrus.Fields{
-					"title": track.Title, "artist": track.Artist, "error": err,
-				}).Error("Failed to register song in database")
-				return
-			}
-
-			// fingerprint song
-			fingerprint, err := fingerprintalgorithm.FingerprintFromSamples(samples, wavInfo.SampleRate, wavInfo.Duration, songID)
-			if err != nil {
-				log.Logger.WithFields(logrus.Fields{
-					"title":  tmpTrack.Title,
-					"artist": tmpTrack.Artist,
-					"error":  err,
-				}).Error("Processing failed at fingerprinting step")
-				// delete songID
-				if delErr := db.DeleteSongByID(songID); delErr != nil {
-					log.Logger.WithError(delErr).Error("Failed to delete orphaned song entry")
-				}
-				return
-			}
-			// fmt.Println(fingerprint)
-			// tmp
-			log.Logger.WithFields(logrus.Fields{
-				"title":             tmpTrack.Title,
-				"artist":            tmpTrack.Artist,
-				"fingerprint count": len(fingerprint),
-			}).Info("Successfully generated fingerprints for track")
-
-			// store fingerprints
-			if err := db.StoreFingerprints(fingerprint); err != nil {
-				log.Logger.WithFields(logrus.Fields{
-					"title": track.Title, "artist": track.Artist, "error": err,
-				}).Error("Failed to store fingerprints")
-				if delErr := db.DeleteSongByID(songID); delErr != nil {
-					log.Logger.WithError(delErr).Error("Failed to delete orphaned song entry")
-				}
+			song := dbSong(track, ytID)
+			if err := storeSong(db, samples, song, opts); err != nil {
 				return
 			}
 
 			// TODO: delete files (after testing)
 
-			log.Logger.WithFields(logrus.Fields{
-				"title":             tmpTrack.Title,
-				"artist":            tmpTrack.Artist,
-				"fingerprint count": len(fingerprint),
-			}).Info("Successfully saved fingerprints in db")
-
 			results <- 1
 		}(t)
 	}
@@ -257,7 +186,21 @@ func download(tracks []sp.Track, path string) error {
 	return nil
 }
 
-func downloadTrack(link string, path string) error {
+// everything spotify and youtube told us about the track
+func dbSong(track sp.Track, ytID string) db.Song {
+	return db.Song{
+		Title:     track.Title,
+		Artist:    track.Artist,
+		Artists:   track.Artists,
+		Album:     track.Album,
+		Duration:  time.Duration(track.Duration) * time.Second,
+		SpotifyID: track.ID,
+		YouTubeID: ytID,
+		SourceURL: track.URL(),
+	}
+}
+
+func downloadTrack(link string, path string, opts AddOptions) error {
 	// get track info
 	log.Logger.Info("Getting Track Info")
 	trackInfo, err := sp.TrackInfo(link)
@@ -273,7 +216,7 @@ func downloadTrack(link string, path string) error {
 	track := []sp.Track{*trackInfo}
 
 	log.Logger.Info("Downloading Track")
-	err = download(track, path)
+	err = download(track, path, opts)
 	if err != nil {
 		return err
 	}
@@ -281,7 +224,7 @@ func downloadTrack(link string, path string) error {
 	return nil
 }
 
-func downloadPlaylist(link string, path string) error {
+func downloadPlaylist(link string, path string, opts AddOptions) error {
 	log.Logger.Info("Getting Playlist Info")
 	tracks, err := sp.PlaylistInfo(link)
 	if err != nil {
@@ -290,7 +233,7 @@ func downloadPlaylist(link string, path string) error {
 	}
 
 	log.Logger.Info("Now downloading playlist")
-	err = download(tracks, path)
+	err = download(tracks, path, opts)
 	if err != nil {
 		log.Logger.WithError(err).WithField("link", link).Error("Could not get playlist's info")
 		return err
@@ -299,7 +242,7 @@ func downloadPlaylist(link string, path string) error {
 	return nil
 }
 
-func GetSongFromSpotify(spotifyLink string) {
+func GetSongFromSpotify(spotifyLink string, opts AddOptions) {
 	err := os.MkdirAll(SONGS_DIRECTORY, 0755)
 	if err != nil {
 		log.Logger.WithError(err).WithField("directory", SONGS_DIRECTORY).Error("Could not create songs directory")
@@ -307,12 +250,12 @@ func GetSongFromSpotify(spotifyLink string) {
 	}
 
 	if strings.Contains(spotifyLink, "track") {
-		err = downloadTrack(spotifyLink, SONGS_DIRECTORY)
+		err = downloadTrack(spotifyLink, SONGS_DIRECTORY, opts)
 		if err != nil {
 			log.Logger.WithError(err).Error("The download process failed")
 		}
 	} else if strings.Contains(spotifyLink, "playlist") {
-		err = downloadPlaylist(spotifyLink, SONGS_DIRECTORY)
+		err = downloadPlaylist(spotifyLink, SONGS_DIRECTORY, opts)
 		if err != nil {
 			log.Logger.WithError(err).Error("The download process failed")
 		}
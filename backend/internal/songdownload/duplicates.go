@@ -0,0 +1,111 @@
+package songdownload
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/match"
+)
+
+// what to do with a song whose audio is already indexed (under another title, or another upload)
+const (
+	DUPLICATES_SKIP   = "skip"
+	DUPLICATES_ALIAS  = "alias"  // record it as another name of the indexed song
+	DUPLICATES_PROMPT = "prompt" // ask on stdin
+	DUPLICATES_KEEP   = "keep"   // index it anyway, no check
+)
+
+type AddOptions struct {
+	Duplicates string
+}
+
+func DefaultAddOptions() AddOptions {
+	return AddOptions{Duplicates: DUPLICATES_SKIP}
+}
+
+func ValidateDuplicates(action string) error {
+	switch action {
+	case DUPLICATES_SKIP, DUPLICATES_ALIAS, DUPLICATES_PROMPT, DUPLICATES_KEEP:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicates action %q, expected %s, %s, %s or %s", action, DUPLICATES_SKIP, DUPLICATES_ALIAS, DUPLICATES_PROMPT, DUPLICATES_KEEP)
+}
+
+// the song wasn't stored because it's already indexed, not a failure
+var errDuplicate = errors.New("song is a duplicate")
+
+// one question on stdin at a time
+var promptMu sync.Mutex
+
+/*
+checks the fingerprints against the index before the song is stored. errDuplicate when it was
+skipped or aliased, nil when it should be stored
+*/
+func checkDuplicate(client db.DbClient, song db.Song, fingerprint map[uint32]fingerprintalgorithm.Couple, action string) error {
+	if action == DUPLICATES_KEEP {
+		return nil
+	}
+
+	hashes := make(map[uint32]uint32, len(fingerprint))
+	for hash, couple := range fingerprint {
+		hashes[hash] = couple.AnchorTimeMs
+	}
+	duplicates, err := match.NewMatcher(client, match.DefaultOptions()).FindDuplicates(context.Background(), hashes, 0, match.DUPLICATE_MIN_SIMILARITY)
+	if err != nil {
+		return fmt.Errorf("error checking for duplicates: %w", err)
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	dupe := duplicates[0]
+	fields := logrus.Fields{
+		"title": song.Title, "artist": song.Artist,
+		"duplicate_of": dupe.Song.ID, "similarity": fmt.Sprintf("%.0f%%", 100*dupe.Similarity),
+	}
+
+	if action == DUPLICATES_PROMPT {
+		action = askDuplicate(song, dupe)
+	}
+	switch action {
+	case DUPLICATES_ALIAS:
+		if err := client.AddAlias(dupe.Song.ID, song); err != nil {
+			return err
+		}
+		log.Logger.WithFields(fields).Infof("Same audio as %q by %s, added as an alias of it", dupe.Song.Title, dupe.Song.Artist)
+		return errDuplicate
+	case DUPLICATES_KEEP:
+		log.Logger.WithFields(fields).Warnf("Same audio as %q by %s, indexing it anyway", dupe.Song.Title, dupe.Song.Artist)
+		return nil
+	default:
+		log.Logger.WithFields(fields).Warnf("Same audio as %q by %s, skipping", dupe.Song.Title, dupe.Song.Artist)
+		return errDuplicate
+	}
+}
+
+func askDuplicate(song db.Song, dupe match.Duplicate) string {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	fmt.Printf("%q by %s sounds like song %d, %q by %s (%.0f%% similar).\n", song.Title, song.Artist, dupe.Song.ID, dupe.Song.Title, dupe.Song.Artist, 100*dupe.Similarity)
+	fmt.Print("[s]kip, add as an [a]lias or [k]eep both? [s] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "a", "alias":
+		return DUPLICATES_ALIAS
+	case "k", "keep":
+		return DUPLICATES_KEEP
+	}
+	return DUPLICATES_SKIP
+}
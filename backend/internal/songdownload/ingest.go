@@ -0,0 +1,137 @@
+package songdownload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ONESHO1/FINDR/backend/internal/audio"
+	"github.com/ONESHO1/FINDR/backend/internal/db"
+	fingerprintalgorithm "github.com/ONESHO1/FINDR/backend/internal/fingerprint-algorithm"
+	"github.com/ONESHO1/FINDR/backend/internal/log"
+	"github.com/ONESHO1/FINDR/backend/internal/utils"
+)
+
+// ffmpeg gets this long to decode a song before it's killed
+const DECODE_TIMEOUT = 5 * time.Minute
+
+// mono samples at 44.1kHz, decoded in memory (ffmpeg only for m4a/mp3/...)
+func loadSong(filePath string) ([]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DECODE_TIMEOUT)
+	defer cancel()
+	return audio.LoadContext(ctx, filePath)
+}
+
+/*
+fingerprint the song, then register it and store the fingerprints in one transaction,
+a failure (or a crash) never leaves a song without its fingerprints behind.
+Same audio as an indexed song is handled by opts.Duplicates, errDuplicate if it didn't get stored
+*/
+func storeSong(client db.DbClient, samples []float64, song db.Song, opts AddOptions) error {
+	fields := logrus.Fields{"title": song.Title, "artist": song.Artist}
+	duration := float64(len(samples)) / audio.TARGET_SAMPLE_RATE
+	if song.Duration == 0 {
+		song.Duration = time.Duration(duration * float64(time.Second))
+	}
+
+	// none by default
+	chain, err := audio.IndexChain()
+	if err != nil {
+		log.Logger.WithFields(fields).WithError(err).Error("Processing failed at pre-processing step")
+		return err
+	}
+	samples = chain.Apply(samples, audio.TARGET_SAMPLE_RATE)
+
+	// fingerprint song, StoreSong fills in the songID
+	fingerprint, err := fingerprintalgorithm.FingerprintFromSamples(samples, audio.TARGET_SAMPLE_RATE, duration, 0)
+	if err != nil {
+		log.Logger.WithFields(fields).WithError(err).Error("Processing failed at fingerprinting step")
+		return err
+	}
+	log.Logger.WithFields(fields).WithField("fingerprint count", len(fingerprint)).Info("Successfully generated fingerprints for track")
+
+	if err := checkDuplicate(client, song, fingerprint, opts.Duplicates); err != nil {
+		if !errors.Is(err, errDuplicate) {
+			log.Logger.WithFields(fields).WithError(err).Error("Processing failed at duplicate check")
+		}
+		return err
+	}
+
+	// register song + store fingerprints
+	songID, err := client.StoreSong(song, fingerprint)
+	if err != nil {
+		log.Logger.WithFields(fields).WithError(err).Error("Failed to store song and fingerprints")
+		return err
+	}
+
+	log.Logger.WithFields(fields).WithFields(logrus.Fields{"song_id": songID, "fingerprint count": len(fingerprint)}).Info("Successfully saved fingerprints in db")
+	return nil
+}
+
+/*
+add a song from a local file (WAV/FLAC natively, anything else through ffmpeg).
+Without a title/artist they come from a "Title - Artist" file name, same as the downloads are named
+*/
+func AddFile(filePath, title, artist string, opts AddOptions) error {
+	if _, err := os.Stat(filePath); err != nil {
+		return err
+	}
+
+	if title == "" || artist == "" {
+		name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+		nameTitle, nameArtist, found := strings.Cut(name, " - ")
+		if !found && title == "" {
+			nameTitle = name
+		}
+		if title == "" {
+			title = strings.TrimSpace(nameTitle)
+		}
+		if artist == "" {
+			artist = strings.TrimSpace(nameArtist)
+		}
+	}
+	if title == "" || artist == "" {
+		return fmt.Errorf("couldn't get the title and artist from %q, pass them with -title and -artist", filepath.Base(filePath))
+	}
+
+	client, err := db.NewDbClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if _, found, err := client.GetSongByKey(utils.GenerateSongKey(title, artist)); err != nil {
+		log.Logger.WithError(err).Error("Failed to check if song exists in DB")
+		return err
+	} else if found {
+		log.Logger.WithFields(logrus.Fields{"title": title, "artist": artist}).Info("Song already exists in the database, skipping.")
+		return nil
+	}
+
+	samples, err := loadSong(filePath)
+	if err != nil {
+		log.Logger.WithError(err).WithField("file", filePath).Error("Processing failed at audio decoding step")
+		return err
+	}
+
+	source, err := filepath.Abs(filePath)
+	if err != nil {
+		source = filePath
+	}
+	err = storeSong(client, samples, db.Song{
+		Title:     title,
+		Artist:    artist,
+		Artists:   []string{artist},
+		SourceURL: source,
+	}, opts)
+	if errors.Is(err, errDuplicate) {
+		return nil
+	}
+	return err
+}
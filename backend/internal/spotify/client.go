@@ -17,7 +17,16 @@ import (
 type Track struct {
 	Title, Artist, Album string
 	Artists              []string
-	Duration             int
+	Duration             int    // seconds
+	ID                   string // spotify track ID
+}
+
+// the track's page on spotify
+func (t Track) URL() string {
+	if t.ID == "" {
+		return ""
+	}
+	return "https://open.spotify.com/track/" + t.ID
 }
 
 func spotifyRequest(endpoint string) (int, string, error){
@@ -115,6 +124,7 @@ func TrackInfo(link string) (*Track, error) {
 		Artists:  artists,
 		Album:    result.Album.Name,
 		Duration: result.Duration / 1000,
+		ID:       spotifyID,
 	}, nil
 }
 
@@ -143,6 +153,7 @@ func PlaylistInfo(url string) ([]Track, error) {
 		var result struct {
 			Items []struct {
 				Track struct {
+					ID       string `json:"id"`
 					Name     string `json:"name"`
 					Duration int    `json:"duration_ms"`
 					Album    struct {
@@ -171,6 +182,7 @@ func PlaylistInfo(url string) ([]Track, error) {
 				Artists:  artists,
 				Duration: track.Duration / 1000,
 				Album:    track.Album.Name,
+				ID:       track.ID,
 			}).buildTrack())
 		}
 
@@ -190,6 +202,7 @@ func (t *Track) buildTrack() *Track {
 		Artists:  t.Artists,
 		Duration: t.Duration,
 		Album:    t.Album,
+		ID:       t.ID,
 	}
 
 	return track
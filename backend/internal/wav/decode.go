@@ -0,0 +1,95 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// fmt chunk audio format codes
+const (
+	FORMAT_PCM        = 1
+	FORMAT_IEEE_FLOAT = 3
+	FORMAT_EXTENSIBLE = 0xFFFE // the real code is in the first 2 bytes of the SubFormat GUID
+)
+
+// bytes per sample in the data chunk, 24-bit is packed into 3
+func (f Format) BytesPerSample() int {
+	return (f.BitsPerSample + 7) / 8
+}
+
+// bytes per interleaved frame (one sample for every channel)
+func (f Format) FrameSize() int {
+	return f.BytesPerSample() * f.Channels
+}
+
+// only the encodings Decode knows about
+func (f Format) Validate() error {
+	if f.Channels <= 0 || f.SampleRate <= 0 {
+		return fmt.Errorf("invalid WAV format: %d channels at %d Hz", f.Channels, f.SampleRate)
+	}
+
+	switch f.AudioFormat {
+	case FORMAT_PCM:
+		switch f.BitsPerSample {
+		case 8, 16, 24, 32:
+			return nil
+		}
+	case FORMAT_IEEE_FLOAT:
+		switch f.BitsPerSample {
+		case 32, 64:
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported WAV encoding: format %d, %d bits (expected 8/16/24/32-bit PCM or 32/64-bit float)", f.AudioFormat, f.BitsPerSample)
+}
+
+/*
+interleaved samples in any supported encoding -> float64s in [-1, 1], same as Samples does for 16-bit.
+A trailing partial sample is dropped
+*/
+func Decode(format Format, input []byte) ([]float64, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	output := make([]float64, len(input)/format.BytesPerSample())
+	decodeInto(format, input, output)
+	return output, nil
+}
+
+// format has to be valid and output exactly len(input)/BytesPerSample long
+func decodeInto(format Format, input []byte, output []float64) {
+	size := format.BytesPerSample()
+
+	switch {
+	case format.AudioFormat == FORMAT_IEEE_FLOAT && size == 4:
+		for i := range output {
+			output[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(input[i*4:])))
+		}
+	case format.AudioFormat == FORMAT_IEEE_FLOAT && size == 8:
+		for i := range output {
+			output[i] = math.Float64frombits(binary.LittleEndian.Uint64(input[i*8:]))
+		}
+	case size == 1:
+		// 8-bit is the odd one out, unsigned with 128 as silence
+		for i := range output {
+			output[i] = (float64(input[i]) - 128) / 128.0
+		}
+	case size == 2:
+		for i := range output {
+			output[i] = float64(int16(binary.LittleEndian.Uint16(input[i*2:]))) / 32768.0
+		}
+	case size == 3:
+		for i := range output {
+			b := input[i*3:]
+			// shift the sign bit up to bit 31 and back down to sign extend
+			sample := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			output[i] = float64(sample) / 8388608.0
+		}
+	case size == 4:
+		for i := range output {
+			output[i] = float64(int32(binary.LittleEndian.Uint32(input[i*4:]))) / 2147483648.0
+		}
+	}
+}
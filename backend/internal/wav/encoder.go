@@ -0,0 +1,139 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// the RIFF sizes are 32 bits, minus the 36 bytes of header they also count
+const maxDataSize = math.MaxUint32 - 36
+
+/*
+writes a WAV file as samples come in (e.g. straight from the mic). The header is written
+with empty sizes first and patched once Close knows how much data there was
+*/
+type Encoder struct {
+	w        io.WriteSeeker
+	format   Format
+	dataSize int64
+	buf      []byte
+	closed   bool
+}
+
+func NewEncoder(w io.WriteSeeker, format Format) (*Encoder, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	if err := writeHeader(w, 0, format); err != nil {
+		return nil, fmt.Errorf("error writing WAV header: %w", err)
+	}
+	return &Encoder{w: w, format: format}, nil
+}
+
+func (e *Encoder) Format() Format {
+	return e.format
+}
+
+// data already in the encoder's format, whole frames only
+func (e *Encoder) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed WAV encoder")
+	}
+	if len(p)%e.format.FrameSize() != 0 {
+		return 0, fmt.Errorf("%d bytes is not a whole number of %d byte frames", len(p), e.format.FrameSize())
+	}
+	if e.dataSize+int64(len(p)) > maxDataSize {
+		return 0, errors.New("WAV data would be over 4GB")
+	}
+
+	n, err := e.w.Write(p)
+	e.dataSize += int64(n)
+	return n, err
+}
+
+// interleaved samples in [-1, 1], anything outside gets clipped
+func (e *Encoder) WriteSamples(samples []float64) error {
+	size := e.format.BytesPerSample()
+	if cap(e.buf) < len(samples)*size {
+		e.buf = make([]byte, len(samples)*size)
+	}
+	buf := e.buf[:len(samples)*size]
+	encodeInto(e.format, samples, buf)
+
+	_, err := e.Write(buf)
+	return err
+}
+
+// fills in the RIFF and data sizes, doesn't close the underlying writer
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	// data chunks are padded to an even size
+	if e.dataSize%2 != 0 {
+		if _, err := e.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := e.w.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking to the WAV header: %w", err)
+	}
+	if err := writeHeader(e.w, uint32(e.dataSize), e.format); err != nil {
+		return fmt.Errorf("error patching the WAV header: %w", err)
+	}
+	_, err := e.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// the 44 byte header, fmt then data
+func writeHeader(w io.Writer, dataSize uint32, format Format) error {
+	blockAlign := format.FrameSize()
+	header := WavHeader{
+		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     36 + dataSize + dataSize%2,
+		Format:        [4]byte{'W', 'A', 'V', 'E'},
+		Subchunk1ID:   [4]byte{'f', 'm', 't', ' '},
+		Subchunk1Size: 16,
+		AudioFormat:   format.AudioFormat,
+		NumChannels:   uint16(format.Channels),
+		SampleRate:    uint32(format.SampleRate),
+		BytesPerSec:   uint32(format.SampleRate * blockAlign),
+		BlockAlign:    uint16(blockAlign),
+		BitsPerSample: uint16(format.BitsPerSample),
+		Subchunk2ID:   [4]byte{'d', 'a', 't', 'a'},
+		Subchunk2Size: dataSize,
+	}
+	return binary.Write(w, binary.LittleEndian, header)
+}
+
+// the inverse of decodeInto, output has to be len(samples)*BytesPerSample long
+func encodeInto(format Format, samples []float64, output []byte) {
+	size := format.BytesPerSample()
+
+	for i, sample := range samples {
+		sample = max(-1, min(1, sample))
+		b := output[i*size:]
+
+		switch {
+		case format.AudioFormat == FORMAT_IEEE_FLOAT && size == 4:
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(sample)))
+		case format.AudioFormat == FORMAT_IEEE_FLOAT && size == 8:
+			binary.LittleEndian.PutUint64(b, math.Float64bits(sample))
+		case size == 1:
+			b[0] = uint8(max(0, min(255, math.Round(sample*128)+128)))
+		case size == 2:
+			binary.LittleEndian.PutUint16(b, uint16(int16(max(math.MinInt16, min(math.MaxInt16, math.Round(sample*32768))))))
+		case size == 3:
+			v := int32(max(-8388608, min(8388607, math.Round(sample*8388608))))
+			b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+		case size == 4:
+			binary.LittleEndian.PutUint32(b, uint32(int32(max(math.MinInt32, min(math.MaxInt32, math.Round(sample*2147483648))))))
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// the parts of the fmt chunk we care about
+type Format struct {
+	AudioFormat   uint16 // FORMAT_PCM or FORMAT_IEEE_FLOAT, extensible files are resolved to their sub format
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+}
+
+/*
+reads the RIFF header and walks the chunks up to the start of the "data" chunk,
+leaving r positioned at the first sample. Works on pipes since it never seeks.
+It reads nothing past the data chunk's header, so the last 4 bytes read are its size field.
+
+dataSize is whatever the header says, streaming writers (ffmpeg to a pipe) put 0 or 0xFFFFFFFF there
+*/
+func ReadHeader(r io.Reader) (format Format, dataSize uint32, err error) {
+	var riff struct {
+		ChunkID   [4]byte
+		ChunkSize uint32
+		Format    [4]byte
+	}
+	if err = binary.Read(r, binary.LittleEndian, &riff); err != nil {
+		return Format{}, 0, fmt.Errorf("error reading RIFF header: %w", err)
+	}
+	if string(riff.ChunkID[:]) != "RIFF" || string(riff.Format[:]) != "WAVE" {
+		return Format{}, 0, fmt.Errorf("not a RIFF/WAVE stream (chunkID: %q, format: %q)", riff.ChunkID[:], riff.Format[:])
+	}
+
+	gotFmt := false
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err = binary.Read(r, binary.LittleEndian, &chunk); err != nil {
+			return Format{}, 0, fmt.Errorf("error reading chunk header: %w", err)
+		}
+
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			if chunk.Size < 16 {
+				return Format{}, 0, fmt.Errorf("fmt chunk too small: %d bytes", chunk.Size)
+			}
+			var fmtChunk struct {
+				AudioFormat   uint16
+				NumChannels   uint16
+				SampleRate    uint32
+				BytesPerSec   uint32
+				BlockAlign    uint16
+				BitsPerSample uint16
+			}
+			if err = binary.Read(r, binary.LittleEndian, &fmtChunk); err != nil {
+				return Format{}, 0, fmt.Errorf("error reading fmt chunk: %w", err)
+			}
+			format = Format{
+				AudioFormat:   fmtChunk.AudioFormat,
+				Channels:      int(fmtChunk.NumChannels),
+				SampleRate:    int(fmtChunk.SampleRate),
+				BitsPerSample: int(fmtChunk.BitsPerSample),
+			}
+
+			read := int64(16)
+			if format.AudioFormat == FORMAT_EXTENSIBLE && chunk.Size >= 40 {
+				/*
+					cbSize, valid bits, channel mask, then a GUID whose first 2 bytes are the actual
+					format code. The valid bits don't matter, samples are left aligned in the container
+				*/
+				var extension struct {
+					Size        uint16
+					ValidBits   uint16
+					ChannelMask uint32
+					SubFormat   [16]byte
+				}
+				if err = binary.Read(r, binary.LittleEndian, &extension); err != nil {
+					return Format{}, 0, fmt.Errorf("error reading fmt chunk extension: %w", err)
+				}
+				format.AudioFormat = binary.LittleEndian.Uint16(extension.SubFormat[:2])
+				read += 24
+			}
+			if err = skip(r, int64(chunk.Size)+int64(chunk.Size%2)-read); err != nil {
+				return Format{}, 0, err
+			}
+			gotFmt = true
+		case "data":
+			if !gotFmt {
+				return Format{}, 0, fmt.Errorf("data chunk before fmt chunk")
+			}
+			if err = format.Validate(); err != nil {
+				return Format{}, 0, err
+			}
+			return format, chunk.Size, nil
+		default:
+			// LIST, fact, JUNK, ... (chunks are padded to an even size)
+			if err = skip(r, int64(chunk.Size)+int64(chunk.Size%2)); err != nil {
+				return Format{}, 0, err
+			}
+		}
+	}
+}
+
+func skip(r io.Reader, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if _, err := io.CopyN(io.Discard, r, n); err != nil {
+		return fmt.Errorf("error skipping %d bytes: %w", n, err)
+	}
+	return nil
+}
+
+/*
+reads the samples of a WAV stream a block at a time instead of loading the whole thing,
+any io.Reader works (files, pipes, http bodies)
+*/
+type Decoder struct {
+	r         *bufio.Reader
+	format    Format
+	remaining int64 // bytes left in the data chunk, -1 when the header doesn't say (streaming writers)
+	buf       []byte
+}
+
+// reads the header, the decoder is left at the first sample
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	br := bufio.NewReader(r)
+	format, dataSize, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := int64(dataSize)
+	if dataSize == 0 || dataSize == 0xFFFFFFFF {
+		remaining = -1
+	}
+	return &Decoder{r: br, format: format, remaining: remaining}, nil
+}
+
+func (d *Decoder) Format() Format {
+	return d.format
+}
+
+/*
+decodes the next block of interleaved samples into dst (as many whole frames as fit) and
+returns how many samples it wrote, io.EOF once the data chunk is done
+*/
+func (d *Decoder) Read(dst []float64) (int, error) {
+	frameSize := d.format.FrameSize()
+	size := (len(dst) / d.format.Channels) * frameSize
+	if d.remaining >= 0 && int64(size) > d.remaining {
+		size = int(d.remaining) - int(d.remaining)%frameSize
+	}
+	if size == 0 {
+		if len(dst) < d.format.Channels {
+			return 0, io.ErrShortBuffer
+		}
+		return 0, io.EOF
+	}
+
+	if cap(d.buf) < size {
+		d.buf = make([]byte, size)
+	}
+	n, err := io.ReadFull(d.r, d.buf[:size])
+	n -= n % frameSize
+	if d.remaining >= 0 {
+		d.remaining -= int64(n)
+	}
+
+	if n == 0 {
+		if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// truncated file, hand over what's there and stop next time
+		d.remaining = 0
+		err = nil
+	}
+
+	samples := n / d.format.BytesPerSample()
+	decodeInto(d.format, d.buf[:n], dst[:samples])
+	return samples, err
+}
+
+// every remaining sample, interleaved
+func (d *Decoder) ReadAll() ([]float64, error) {
+	var output []float64
+	block := make([]float64, 8192*d.format.Channels)
+	for {
+		n, err := d.Read(block)
+		output = append(output, block[:n]...)
+		if errors.Is(err, io.EOF) {
+			return output, nil
+		}
+		if err != nil {
+			return output, err
+		}
+	}
+}
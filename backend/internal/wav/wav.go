@@ -1,69 +1,19 @@
 package wav
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 
 	"github.com/ONESHO1/FINDR/backend/internal/log"
-	"github.com/sirupsen/logrus"
 )
 
-// convert the audio file to a wav file
-func ConvertToWav(filePath string, channels int) (wavFilePath string, err error) {
-	_, err = os.Stat(filePath)
-	if err != nil {
-		log.Logger.WithError(err).WithField("input Path", filePath).Error("Input file for WAV conversion not found")
-		return "", err
-	}
-
-	// we want single channel audio for the fingerprinting process
-	if channels != 1 {
-		channels = 1
-	}
-
-	fileExtention := filepath.Ext(filePath)
-
-	wavFilePath = strings.TrimSuffix(filePath, fileExtention) + ".wav"
-
-	// TODO: use a temporary file in case wav already exists
-
-	cmd := exec.Command(
-		"ffmpeg",
-		"-y",					// overwrite output file if it already exists
-		"-i", 					// set input 
-		filePath,				// input file 
-		"-c", 					// set Codec
-		"pcm_s16le",			// Pulse Code Modulation (standard for raw, uncompressed audio) | signed 16-bit, little-endian. This is the standard bit depth and byte order for CD-quality audio.
-		"-ar", 					// Audio Rate
-		"44100",				// 44100 Hz (written in the research paper)
-		"-ac", 					// set number of audio channels
-		fmt.Sprint(channels),	// 1 chanel
-		wavFilePath,			// output file path
-	)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Logger.WithFields(logrus.Fields{
-			"input path":    filePath,
-			"output path":   wavFilePath,
-			"ffmpeg_output": output,
-			"error": err,
-		}).Error("ffmpeg failed to convert to WAV")
-
-		return "", err
-	}
-
-	return wavFilePath, nil
-}
-
 type WavInformation struct {
 	Channels   int
 	SampleRate int
+	Format     Format
 	Data       []byte
 	Duration   float64
 }
@@ -85,80 +35,62 @@ type WavHeader struct {
 	Subchunk2Size uint32
 }
 
-// chatgpt for the actual values wooooooooooooo !!!!!!!!!!!!
-func WriteWavHeader(file *os.File, dataSize uint32, sampleRate, bitsPerSample, channels uint16) error {
-	header := WavHeader{
-		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
-		ChunkSize:     uint32(36 + dataSize),
-		Format:        [4]byte{'W', 'A', 'V', 'E'},
-		Subchunk1ID:   [4]byte{'f', 'm', 't', ' '},
-		Subchunk1Size: uint32(16),						// for PCM (look up wav header details)
-		AudioFormat:   uint16(1), 						// PCM format
-		NumChannels:   uint16(channels),
-		SampleRate:    uint32(sampleRate),
-		BytesPerSec:   uint32(sampleRate) * uint32(channels) * uint32(bitsPerSample/8),
-		BlockAlign:    uint16(channels * bitsPerSample / 8),
-		BitsPerSample: uint16(bitsPerSample),
-		Subchunk2ID:   [4]byte{'d', 'a', 't', 'a'},
-		Subchunk2Size: dataSize,
-	}
-	
-	err := binary.Write(file, binary.LittleEndian, header)
-	return err
+// integer PCM header for dataSize bytes of bitsPerSample samples, channels interleaved.
+// Use an Encoder when the data size isn't known up front
+func WriteWavHeader(w io.Writer, dataSize uint32, sampleRate uint32, bitsPerSample, channels uint16) error {
+	return writeHeader(w, dataSize, Format{
+		AudioFormat:   FORMAT_PCM,
+		Channels:      int(channels),
+		SampleRate:    int(sampleRate),
+		BitsPerSample: int(bitsPerSample),
+	})
 }
 
 // get the required wav informaton from the header of the wav file
 func WavInfo(filePath string) (*WavInformation, error) {
-	data, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
 		log.Logger.WithError(err).WithField("file Path", filePath).Error("Can't read wav file")
 		return nil, err
 	}
+	defer file.Close()
 
-
-	// till 44 is the header 
-	if len(data) < 44 {
-		err := fmt.Errorf("file size is %d bytes, which is smaller than a 44-byte WAV header", len(data))
-		log.Logger.WithError(err).WithField("file Path", filePath).Error(err)
+	// walks past LIST/fact/JUNK/... instead of assuming the data starts at byte 44
+	reader := bufio.NewReader(file)
+	format, dataSize, err := ReadHeader(reader)
+	if err != nil {
+		log.Logger.WithError(err).WithField("filePath", filePath).Error("wrong WAV header format")
 		return nil, err
 	}
 
-	var header WavHeader
-	err = binary.Read(bytes.NewReader(data[:44]), binary.LittleEndian, &header)
+	data, err := io.ReadAll(reader)
 	if err != nil {
-		log.Logger.WithError(err).WithField("file Path", filePath).Error("error reading the header")
+		log.Logger.WithError(err).WithField("file Path", filePath).Error("error reading the wav data")
 		return nil, err
 	}
-	if string(header.Format[:]) != "WAVE" || string(header.ChunkID[:]) != "RIFF" || header.AudioFormat != 1 {
-		err := fmt.Errorf("wrong WAV header format")
-		log.Logger.WithFields(logrus.Fields{
-			"filePath":    filePath,
-			"chunkID":     string(header.ChunkID[:]),
-			"format":      string(header.Format[:]),
-			"audioFormat": header.AudioFormat,
-		}).Error(err)
-		return nil, err
+	// anything after the data chunk is more chunks, streaming writers leave the size at 0 or 0xFFFFFFFF
+	if dataSize != 0 && dataSize != 0xFFFFFFFF && int64(dataSize) < int64(len(data)) {
+		data = data[:dataSize]
 	}
+	data = data[:len(data)-len(data)%format.FrameSize()]
 
 	info := &WavInformation{
-		Channels: int(header.NumChannels),
-		SampleRate: int(header.SampleRate),
-		Data: data[44:],
-	}
-
-	// must be PCM formal during the conversion for this to be accurate
-	if header.BitsPerSample == 16 {
-		info.Duration = float64(len(info.Data)) / float64(int(header.NumChannels)*2*int(header.SampleRate))
-	} else {
-		err := fmt.Errorf("wrong bit depth: expected 16, got %d", header.BitsPerSample)
-		log.Logger.WithField("filePath", filePath).Error(err)
-		return nil, err
+		Channels:   format.Channels,
+		SampleRate: format.SampleRate,
+		Format:     format,
+		Data:       data,
+		Duration:   float64(len(data)/format.FrameSize()) / float64(format.SampleRate),
 	}
 
 	return info, nil
 }
 
-// decoding/normalization of the raw byte stream into a slice of float64 numbers, where each number represents the sound wave's amplitude at a specific point in time.
+// the data decoded into [-1, 1], whatever the bit depth
+func (info *WavInformation) Samples() ([]float64, error) {
+	return Decode(info.Format, info.Data)
+}
+
+// decoding/normalization of the raw 16-bit byte stream into a slice of float64 numbers, where each number represents the sound wave's amplitude at a specific point in time.
 func Samples(input []byte) ([]float64, error) {
 	if len(input) % 2 != 0 {
 		err := fmt.Errorf("audio data has an odd number of bytes (%d), prolly corrupted", len(input))